@@ -0,0 +1,3 @@
+module github.com/Pingumaniac/NAND2TETRIS-IN-GO/HACK-ASSEMBLER
+
+go 1.18
@@ -0,0 +1,354 @@
+/*
+Package hackasm translates programs written in the Hack assembly language of the Nand2Tetris
+course into Hack machine code. Assemble() runs the whole translation on any io.Reader, and
+AssembleObject() and Link() build one program out of several files. The Parser, Code and
+SymbolTable modules described in chapter 6 of the book remain available to callers that drive
+the translation themselves.
+*/
+package hackasm
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Options of Assemble()
+type Options struct {
+	FileName   string         // name of the input in error messages, "<input>" if empty
+	Predefined map[string]int // symbols predefined in addition to the 23 of the Hack language, e.g. {"LED": 24577}
+	Listing    io.Writer      // receives the .lst listing of the program unless nil
+	SourceMap  io.Writer      // receives the source map of the program, as written by WriteSourceMap(), unless nil
+	Optimize   bool           // remove redundant instructions before the addresses are assigned
+	Aliases    bool           // accept operands and destinations in another order, such as M+D or DM
+	Pseudo     bool           // expand the pseudo-instructions such as PUSH D and GOTO target, which strict Hack assembly lacks
+
+	Optimizations  *OptimizationReport // receives the instructions saved by Optimize unless nil
+	InstructionSet *InstructionSet     // the mnemonics of the CPU, the standard Hack CPU if nil
+	Includes       *[]string           // receives the paths of the files named by .include unless nil, even if the program is invalid
+	Usage          *UsageReport        // receives the ROM and RAM used by the program unless nil
+
+	MaxROMWords        int // the most words the machine code may take, e.g. 32768; 0 for no limit
+	MaxVariableAddress int // the highest RAM address a variable may take, e.g. 255 below the stack; 0 for no limit
+}
+
+/* Assembles the Hack assembly program read from reader and returns its machine code words along with
+the symbol table that resolved its labels, variables and constants. The errors of an invalid program
+are returned together as an ErrorList, in which case nothing is written to options.Listing and
+options.SourceMap. */
+func Assemble(reader io.Reader, options Options) ([]uint16, *SymbolTable, error) {
+	var listing bytes.Buffer
+	parser, words, symboltable, err1 := assemble(reader, options, false, 0, listingBuffer(&listing, options))
+	if err1 != nil {
+		return nil, nil, err1
+	}
+	if options.Listing != nil {
+		if _, err2 := options.Listing.Write(listing.Bytes()); err2 != nil {
+			return nil, nil, err2
+		}
+	}
+	if options.SourceMap != nil {
+		if err3 := WriteSourceMap(options.SourceMap, newSourceMap(parser, symboltable)); err3 != nil {
+			return nil, nil, err3
+		}
+	}
+	if options.Usage != nil {
+		*options.Usage = newUsageReport(words, symboltable)
+	}
+	return words, symboltable, nil
+}
+
+// Returns buffer, which holds the listing until the program turns out to be valid, or nil if options.Listing is nil
+func listingBuffer(buffer *bytes.Buffer, options Options) io.Writer {
+	if options.Listing == nil {
+		return nil // no listing is formatted at all
+	}
+	return buffer
+}
+
+/* Runs both passes over input, which is read once, and returns the parser along with the words and
+the symbol table. With relocatable set, symbols that the file does not define are left to the linker, and the labels
+are placed from the ROM address romBase onwards. */
+func assemble(input io.Reader, options Options, relocatable bool, romBase int, listing io.Writer) (*Parser, []uint16, *SymbolTable, error) {
+	var fileName string = options.FileName
+	if fileName == "" {
+		fileName = "<input>"
+	}
+	var symboltable *SymbolTable = InitSymbolTable()
+	for symbol, address := range options.Predefined {
+		if isSymbol(symbol) == false {
+			return nil, nil, nil, fmt.Errorf("invalid predefined symbol '%s'", symbol)
+		} else if address < 0 || address > 32767 {
+			return nil, nil, nil, fmt.Errorf("address %d of predefined symbol '%s' out of range 0..32767", address, symbol)
+		}
+		symboltable.AddEntry(symbol, address, PREDEFINED_SYMBOL, 0)
+	}
+	var parser *Parser = InitParser(input, fileName)
+	parser.relocatable = relocatable
+	if options.InstructionSet != nil {
+		parser.instructionset = options.InstructionSet
+	}
+	parser.aliases = options.Aliases
+	parser.pseudo = options.Pseudo
+	parser.maxROMWords = options.MaxROMWords
+	parser.maxVariableAddress = options.MaxVariableAddress
+	parser.ramAddress = romBase
+	if options.Optimize {
+		var report OptimizationReport = parser.preprocessor.optimize()
+		if options.Optimizations != nil {
+			*options.Optimizations = report
+		}
+	}
+	symboltable = addLCOMMAND(parser, symboltable)
+	if options.Includes != nil {
+		*options.Includes = parser.preprocessor.included
+	}
+	if err1 := parser.preprocessor.err(); err1 != nil {
+		return nil, nil, nil, err1
+	}
+	words, err2 := generateHack(parser, symboltable, listing)
+	if err2 != nil {
+		return nil, nil, nil, err2
+	}
+	return parser, words, symboltable, nil
+}
+
+func addLCOMMAND(parser *Parser, symboltable *SymbolTable) *SymbolTable {
+	var labelLines map[string]int = map[string]int{} // line of each label declared so far
+	var scope *localScope = initLocalScope()
+	for parser.HasMoreCommands() {
+		parser.Advance()
+		if parser.CommandType() == L_COMMAND {
+			var label string = parser.Symbol()
+			if strings.HasSuffix(parser.currentCommand, ")") == false {
+				parser.errorAt(parser.column, "unbalanced '(' in label declaration '%s'", parser.currentCommand)
+			} else if isSymbol(label) == false && isNumericLabel(label) == false {
+				parser.errorAt(parser.column+1, "invalid label '%s'", label)
+			} else {
+				label = parser.declareLabel(scope, label) // the full name of a local label
+				if line, ok := labelLines[label]; ok {
+					parser.errorAt(parser.column+1, "duplicate label '%s' (first declared at line %d)", label, line)
+				} else {
+					labelLines[label] = parser.lineNumber
+				}
+			}
+			symboltable.AddEntry(label, parser.ramAddress, LABEL_SYMBOL, parser.lineNumber)
+		} else if parser.CommandType() == DIRECTIVE_COMMAND {
+			if directive, _, _, _ := parser.directive(); directive == ".export" || directive == ".import" {
+				parser.declareLinkage()
+			} else {
+				parser.declareConstant()
+			}
+		} else if parser.CommandType() == PSEUDO_COMMAND {
+			continue // its expansion follows
+		} else {
+			if parser.CommandType() == A_COMMAND {
+				parser.referToLabel(scope, parser.Symbol())
+			}
+			parser.ramAddress = parser.ramAddress + 1
+		}
+	}
+	parser.errors = append(parser.errors, parser.preprocessor.errors...)
+	parser.checkLocalLabels(scope, symboltable)
+	evaluateConstants(parser, symboltable, labelLines)
+	checkLinkage(parser, symboltable)
+	return symboltable
+}
+
+// Records the constant declared by the current directive, to be evaluated by evaluateConstants()
+func (parser *Parser) declareConstant() {
+	directive, name, expression, column := parser.directive()
+	if directive != ".equ" && directive != ".define" {
+		parser.errorAt(parser.column, "unknown directive '%s'", directive)
+		return
+	}
+	if isSymbol(name) == false {
+		parser.errorAt(parser.column+len(directive)+1, "expected a constant name after %s", directive)
+		return
+	}
+	if strings.TrimSpace(expression) == "" {
+		parser.errorAt(column, "expected a value for constant '%s'", name)
+		return
+	}
+	parser.constants = append(parser.constants, &constantDefinition{name: name, expression: expression, source: parser.currentSource, column: column})
+}
+
+/* Evaluates the constants declared by .equ and .define against the labels collected by addLCOMMAND()
+and adds them to the symbol table. A constant may use labels, predefined symbols and other constants. */
+func evaluateConstants(parser *Parser, symboltable *SymbolTable, labelLines map[string]int) {
+	var definitions map[string]*constantDefinition = map[string]*constantDefinition{}
+	var valid []*constantDefinition
+	for _, definition := range parser.constants {
+		if previous, ok := definitions[definition.name]; ok {
+			parser.errorAtSource(definition.source, definition.column, "constant '%s' already declared at line %d", definition.name, previous.source.lineNumber)
+		} else if line, ok := labelLines[definition.name]; ok {
+			parser.errorAtSource(definition.source, definition.column, "constant '%s' already declared as a label at line %d", definition.name, line)
+		} else if symboltable.kinds[definition.name] == PREDEFINED_SYMBOL && symboltable.Contains(definition.name) {
+			parser.errorAtSource(definition.source, definition.column, "constant '%s' redefines a predefined symbol", definition.name)
+		} else {
+			definitions[definition.name] = definition
+			valid = append(valid, definition)
+		}
+	}
+
+	var resolve func(definition *constantDefinition) (int, bool)
+	resolve = func(definition *constantDefinition) (int, bool) {
+		if definition.state == 1 {
+			parser.errorAtSource(definition.source, definition.column, "constant '%s' is defined in terms of itself", definition.name)
+			definition.state = 2
+			return 0, false
+		} else if definition.state == 2 {
+			return definition.value, definition.ok
+		}
+		definition.state = 1
+		value, err := evaluate(definition.expression, func(name string) (int, bool) {
+			if other, ok := definitions[name]; ok {
+				value, _ := resolve(other) // an invalid constant has been reported already
+				return value, true
+			}
+			if symboltable.Contains(name) {
+				return symboltable.GetAddress(name), true
+			}
+			return 0, false
+		})
+		if err != nil {
+			parser.errorAtSource(definition.source, definition.column+err.offset, "%s", err.message)
+		}
+		if definition.state == 1 {
+			definition.state = 2
+			definition.value = value
+			definition.ok = err == nil
+		}
+		return definition.value, definition.ok
+	}
+	for _, definition := range valid {
+		resolve(definition)
+	}
+	for _, definition := range valid {
+		if definition.ok {
+			symboltable.AddEntry(definition.name, definition.value, CONSTANT_SYMBOL, definition.source.lineNumber)
+		}
+	}
+}
+
+const listingHeader = " ROM  BINARY            HEX     LINE  SOURCE\n"
+
+// Returns the listing row of an instruction: its ROM address, the word in binary and hex, and its source line
+func listingRow(romAddress int, word uint16, parser *Parser) string {
+	return fmt.Sprintf("%04d  %016b  %04X  %6d  %s", romAddress, word, word, parser.lineNumber, parser.currentLine())
+}
+
+/* Translates the commands of the parser into Hack machine code and returns its words, which the
+output formats encode. Unless listing is nil, a listing that shows the ROM address, the binary and
+hexadecimal word and the source line of every instruction is written to it as well. Label lines
+show the ROM address they resolved to, and variables show the RAM address assigned to them. */
+func generateHack(parser *Parser, symboltable *SymbolTable, listing io.Writer) ([]uint16, error) {
+	parser.rewind() // the errors in the macros were recorded by the first pass
+	parser.ramAddress = 16
+
+	var words []uint16 = make([]uint16, 0, len(parser.commands))
+	parser.wordCommands = make([]int, 0, len(parser.commands))
+	parser.wordLabels = make([]string, 0, len(parser.commands))
+	var bufferedListing *bufio.Writer
+	if listing != nil {
+		bufferedListing = bufio.NewWriter(listing)
+		bufferedListing.WriteString(listingHeader)
+	}
+	var romAddress int = 0
+	var word uint16
+	var label string = "" // the nearest label declared so far
+	for parser.HasMoreCommands() {
+		parser.Advance()
+		if parser.CommandType() == A_COMMAND {
+			symbol := parser.Symbol()
+			address, isConstant := parser.constant(symboltable)
+			if isConstant == false {
+				if symboltable.Contains(symbol) == false && parser.relocatable {
+					parser.relocateSymbol(romAddress, symbol) // the linker fills in the address
+				} else if symboltable.Contains(symbol) == false {
+					if parser.maxVariableAddress > 0 && parser.ramAddress > parser.maxVariableAddress {
+						parser.errorAt(parser.column+1, "variable '%s' would be at RAM[%d], above the limit of RAM[%d]", symbol, parser.ramAddress, parser.maxVariableAddress)
+					}
+					symboltable.AddEntry(symbol, parser.ramAddress, VARIABLE_SYMBOL, parser.lineNumber)
+					address = parser.ramAddress
+					parser.ramAddress = parser.ramAddress + 1
+				} else {
+					address = symboltable.GetAddress(symbol)
+					symboltable.markUsed(symbol, parser.lineNumber)
+				}
+				if address > 32767 { // Case: a label past the end of the 32K ROM
+					parser.errorAt(parser.column+1, "address %d of '%s' does not fit in 15 bits", address, symbol)
+				}
+			}
+
+			word = uint16(address) // the words are only turned into text by the output formats
+			if bufferedListing != nil {
+				var row string = listingRow(romAddress, word, parser)
+				if isConstant == false && symboltable.kinds[symbol] == VARIABLE_SYMBOL {
+					row = row + "    ; " + symbol + " = RAM[" + strconv.Itoa(address) + "]"
+				}
+				bufferedListing.WriteString(row + "\n")
+			}
+			words = append(words, word)
+			parser.wordCommands = append(parser.wordCommands, parser.replayed-1)
+			parser.wordLabels = append(parser.wordLabels, label)
+			romAddress = romAddress + 1
+		}
+		if parser.CommandType() == C_COMMAND {
+			word = parser.cCommand()
+			if bufferedListing != nil {
+				bufferedListing.WriteString(listingRow(romAddress, word, parser) + "\n")
+			}
+			words = append(words, word)
+			parser.wordCommands = append(parser.wordCommands, parser.replayed-1)
+			parser.wordLabels = append(parser.wordLabels, label)
+			romAddress = romAddress + 1
+		}
+		if parser.CommandType() == DIRECTIVE_COMMAND && bufferedListing != nil {
+			directive, name, _, _ := parser.directive()
+			var row string = fmt.Sprintf("%4s  %16s  %4s  %6d  %s", "", "", "", parser.lineNumber, parser.currentLine())
+			if directive == ".equ" || directive == ".define" {
+				row = row + "    ; " + name + " = " + strconv.Itoa(symboltable.GetAddress(name))
+			}
+			bufferedListing.WriteString(row + "\n")
+		}
+		if parser.CommandType() == PSEUDO_COMMAND && bufferedListing != nil {
+			var row string = fmt.Sprintf("%04d  %16s  %4s  %6d  %s", romAddress, "", "", parser.lineNumber, parser.currentLine())
+			bufferedListing.WriteString(row + "\n") // the rows of its expansion follow
+		}
+		if parser.CommandType() == L_COMMAND {
+			label = parser.Symbol()
+		}
+		if parser.CommandType() == L_COMMAND && bufferedListing != nil {
+			var row string = fmt.Sprintf("%04d  %16s  %4s  %6d  %s", symboltable.GetAddress(label), "", "", parser.lineNumber, parser.currentLine())
+			bufferedListing.WriteString(row + "    ; " + label + " = ROM[" + strconv.Itoa(symboltable.GetAddress(label)) + "]\n")
+		}
+	}
+	if err3 := parser.preprocessor.err(); err3 != nil {
+		return nil, err3
+	}
+	if parser.maxROMWords > 0 && len(words) > parser.maxROMWords {
+		parser.errorAtSource(parser.commands[parser.wordCommands[parser.maxROMWords]], 0, "the program takes %d words, more than the limit of %d; this is the first word past it", len(words), parser.maxROMWords)
+	} else if len(words) > romSize {
+		parser.errorAtSource(parser.commands[parser.wordCommands[romSize]], 0, "the program takes %d words, more than the %d words of the ROM; this is the first word past it", len(words), romSize)
+	}
+	if len(parser.errors) > 0 { // Case: the source is invalid, so the generated code is discarded
+		sort.SliceStable(parser.errors, func(i, j int) bool {
+			if parser.errors[i].Line != parser.errors[j].Line {
+				return parser.errors[i].Line < parser.errors[j].Line
+			}
+			return parser.errors[i].Column < parser.errors[j].Column
+		})
+		return nil, parser.errors
+	}
+	if bufferedListing != nil {
+		if err4 := bufferedListing.Flush(); err4 != nil {
+			return nil, err4
+		}
+	}
+	return words, nil
+}
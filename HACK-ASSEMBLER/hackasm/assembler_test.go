@@ -0,0 +1,212 @@
+package hackasm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// The programs of chapter 6, along with the .hack files that the reference assembler translates them into
+var asmFiles = []string{"Add", "Max", "Rect", "Pong"}
+
+// Reads the file name.ext from the asm_files folder
+func readAsmFile(t testing.TB, name string, ext string) []byte {
+	t.Helper()
+	contents, err := ioutil.ReadFile(filepath.Join("..", "asm_files", name+ext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return contents
+}
+
+// Assembles source and fails the test if it is invalid
+func mustAssemble(t testing.TB, source string, options Options) ([]uint16, *SymbolTable) {
+	t.Helper()
+	words, symboltable, err := Assemble(strings.NewReader(source), options)
+	if err != nil {
+		t.Fatalf("%s: %v", options.FileName, err)
+	}
+	return words, symboltable
+}
+
+// Returns the words in the .hack text format
+func hackText(t testing.TB, words []uint16) string {
+	t.Helper()
+	var hack bytes.Buffer
+	if err := EncodeWords(&hack, words, "hack", ""); err != nil {
+		t.Fatal(err)
+	}
+	return hack.String()
+}
+
+func TestAssembleMatchesReference(t *testing.T) {
+	for _, name := range asmFiles {
+		t.Run(name, func(t *testing.T) {
+			words, _ := mustAssemble(t, string(readAsmFile(t, name, ".asm")), Options{FileName: name + ".asm"})
+			var expected string = strings.Replace(string(readAsmFile(t, name, ".hack")), "\r\n", "\n", -1)
+			if got := hackText(t, words); got != expected {
+				t.Errorf("%s.asm does not assemble into %s.hack", name, name)
+			}
+		})
+	}
+}
+
+// The input is read once, so a reader that hands out one byte at a time must give the same words and variables
+func TestAssembleReadsInputOnce(t *testing.T) {
+	for _, name := range asmFiles {
+		t.Run(name, func(t *testing.T) {
+			var source []byte = readAsmFile(t, name, ".asm")
+			expected, expectedTable := mustAssemble(t, string(source), Options{FileName: name + ".asm"})
+			words, symboltable, err := Assemble(iotest.OneByteReader(bytes.NewReader(source)), Options{FileName: name + ".asm"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hackText(t, words) != hackText(t, expected) {
+				t.Errorf("the words differ when the input is read one byte at a time")
+			}
+			for symbol, address := range expectedTable.symbols {
+				if symboltable.GetAddress(symbol) != address || symboltable.Kind(symbol) != expectedTable.Kind(symbol) {
+					t.Errorf("%s is at %d, expected %d", symbol, symboltable.GetAddress(symbol), address)
+				}
+			}
+		})
+	}
+}
+
+// Variables are allocated from RAM[16] in the order of their first use, after every label is known
+func TestAssembleAllocatesVariables(t *testing.T) {
+	var source string = "@i\nM=1\n@LOOP\n0;JMP\n@sum\nM=0\n(LOOP)\n@i\nD=M\n@end\nD;JEQ\n"
+	words, symboltable := mustAssemble(t, source, Options{FileName: "Sum.asm"})
+	var tests = []struct {
+		symbol  string
+		address int
+		kind    int
+	}{
+		{"i", 16, VARIABLE_SYMBOL},
+		{"sum", 17, VARIABLE_SYMBOL},
+		{"end", 18, VARIABLE_SYMBOL},
+		{"LOOP", 6, LABEL_SYMBOL},
+	}
+	for _, test := range tests {
+		if symboltable.GetAddress(test.symbol) != test.address || symboltable.Kind(test.symbol) != test.kind {
+			t.Errorf("%s: address %d kind %d, expected address %d kind %d", test.symbol, symboltable.GetAddress(test.symbol), symboltable.Kind(test.symbol), test.address, test.kind)
+		}
+	}
+	if words[2] != 6 || words[6] != 16 {
+		t.Errorf("@LOOP loads %d and the second @i loads %d, expected 6 and 16", words[2], words[6])
+	}
+}
+
+func TestAssembleValues(t *testing.T) {
+	var tests = []struct {
+		source string
+		word   uint16
+	}{
+		{"@0x4000", 0x4000},
+		{"@0b1010", 10},
+		{"@'A'", 65},
+		{"@'\\n'", 128},
+		{"@-1", 0x7FFF},
+		{"@~0x4000", 0x3FFF},
+		{"@SCREEN+32*3", 16480},
+		{".equ ROW 3\n@ROW*2", 6},
+		{"@LED", 24577},
+		{"D=D+A", 0xE090},
+		{"AM=M-1", 0xFCA8},
+		{"0;JMP", 0xEA87},
+		{"M = D ; JGT", 0xE309},
+	}
+	for _, test := range tests {
+		words, _ := mustAssemble(t, test.source, Options{FileName: "Value.asm", Predefined: map[string]int{"LED": 24577}})
+		if len(words) == 0 || words[len(words)-1] != test.word {
+			t.Errorf("%q assembles into %v, expected last word %#04x", test.source, words, test.word)
+		}
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	var tests = []struct {
+		source   string
+		expected []string
+	}{
+		{"D=D+2\n", []string{"E.asm:1:3: unknown comp 'D+2'"}},
+		{"@x\nX=D\n", []string{"E.asm:2:1: unknown dest 'X'"}},
+		{"D;JMPX\n", []string{"E.asm:1:3: unknown jump 'JMPX'"}},
+		{"(LOOP)\n(LOOP)\n", []string{"E.asm:2:2: duplicate label 'LOOP' (first declared at line 1)"}},
+		{"@40000\n", []string{"E.asm:1:2: value 40000 of '40000' does not fit in 15 bits"}},
+		{"@0x4G00\n", []string{"E.asm:1:2: malformed number '0x4G00'"}},
+		{"  #\n", []string{"E.asm:1:3: stray character '#'"}},
+		{"@1b\n", []string{"E.asm:1:2: no label (1) before '@1b'"}},
+		{"/* open\n@1\n", []string{"E.asm:1:1: unterminated block comment"}},
+		{".macro J a\n  @\\a\n  D=D+2\n.endm\n  J 5\n", []string{"E.asm:5:3: unknown comp 'D+2' (expanded from macro J at E.asm:3:5)"}},
+		{"D=D+2\n@0\nX=D;JMPX\n", []string{
+			"E.asm:1:3: unknown comp 'D+2'",
+			"E.asm:3:1: unknown dest 'X'",
+			"E.asm:3:5: unknown jump 'JMPX'",
+		}},
+	}
+	for _, test := range tests {
+		words, _, err := Assemble(strings.NewReader(test.source), Options{FileName: "E.asm"})
+		errorList, ok := err.(ErrorList)
+		if ok == false {
+			t.Errorf("%q: expected an ErrorList, got words %v and error %v", test.source, words, err)
+			continue
+		}
+		var messages []string
+		for _, sourceError := range errorList {
+			messages = append(messages, sourceError.Error())
+		}
+		if strings.Join(messages, "\n") != strings.Join(test.expected, "\n") {
+			t.Errorf("%q:\ngot      %q\nexpected %q", test.source, messages, test.expected)
+		}
+		if words != nil {
+			t.Errorf("%q: an invalid program returned %d words", test.source, len(words))
+		}
+	}
+}
+
+// Nothing is written to the listing and the source map of an invalid program
+func TestAssembleWritesNothingOnError(t *testing.T) {
+	var listing, sourceMap bytes.Buffer
+	_, _, err := Assemble(strings.NewReader("@1\nD=D+2\n"), Options{FileName: "E.asm", Listing: &listing, SourceMap: &sourceMap})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if listing.Len() != 0 || sourceMap.Len() != 0 {
+		t.Errorf("wrote %d bytes of listing and %d bytes of source map", listing.Len(), sourceMap.Len())
+	}
+}
+
+// The ROM holds 32768 words, so a longer program and a label past its end are errors even without a limit of MaxROMWords
+func TestAssembleROMLimit(t *testing.T) {
+	var tests = []struct {
+		source   string
+		maxROM   int
+		expected []string
+	}{
+		{strings.Repeat("D=0\n", 32768) + "(END)\n", 0, nil},
+		{strings.Repeat("D=0\n", 32769), 0, []string{"E.asm:32769: the program takes 32769 words, more than the 32768 words of the ROM; this is the first word past it"}},
+		{strings.Repeat("D=0\n", 32770) + "(FAR)\n@FAR\n0;JMP\n", 0, []string{
+			"E.asm:32769: the program takes 32772 words, more than the 32768 words of the ROM; this is the first word past it",
+			"E.asm:32772:2: address 32770 of 'FAR' does not fit in 15 bits",
+		}},
+		{strings.Repeat("D=0\n", 32769), 32766, []string{"E.asm:32767: the program takes 32769 words, more than the limit of 32766; this is the first word past it"}},
+	}
+	for index, test := range tests {
+		_, _, err := Assemble(strings.NewReader(test.source), Options{FileName: "E.asm", MaxROMWords: test.maxROM})
+		var messages []string
+		if errorList, ok := err.(ErrorList); ok {
+			for _, sourceError := range errorList {
+				messages = append(messages, sourceError.Error())
+			}
+		} else if err != nil {
+			t.Fatalf("test %d: %v", index, err)
+		}
+		if strings.Join(messages, "\n") != strings.Join(test.expected, "\n") {
+			t.Errorf("test %d:\ngot      %q\nexpected %q", index, messages, test.expected)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package hackasm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+// Assembles each of the asm_files per run, read from memory so that the times leave out the disk
+func benchmarkAssemble(b *testing.B, options Options, format string) {
+	for _, name := range asmFiles {
+		b.Run(name, func(b *testing.B) {
+			var source []byte = readAsmFile(b, name, ".asm")
+			options.FileName = name + ".asm"
+			b.ReportAllocs()
+			b.SetBytes(int64(len(source)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				words, _, err := Assemble(bytes.NewReader(source), options)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if format != "" {
+					EncodeWords(ioutil.Discard, words, format, options.FileName)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkAssemble(b *testing.B) {
+	benchmarkAssemble(b, Options{}, "")
+}
+
+func BenchmarkAssembleHack(b *testing.B) {
+	benchmarkAssemble(b, Options{}, "hack")
+}
+
+func BenchmarkAssembleOptimized(b *testing.B) {
+	benchmarkAssemble(b, Options{Optimize: true}, "")
+}
@@ -0,0 +1,159 @@
+package hackasm
+
+import (
+	"fmt"
+	"strings"
+)
+
+/* General description: "Explains how two versions of the same machine code differ", e.g. a
+student's .hack file and the reference. The words are aligned by ROM address, and every word that
+differs is decoded into its fields, such as
+
+	addr 7: comp D+M vs D+A (a-bit differs)
+	addr 9: A-instruction value 17 vs 16
+
+A C-instruction is 111a c1c2c3c4c5c6 d1d2d3 j1j2j3, as in chapter 4 of the book. */
+
+// The names of the bits of a C-instruction, from bit 12 down to bit 0
+var c_command_bits = []string{"a", "c1", "c2", "c3", "c4", "c5", "c6", "d1", "d2", "d3", "j1", "j2", "j3"}
+
+// A ROM address at which two programs differ, as found by Compare()
+type Mismatch struct {
+	Address     int
+	First       int    // the word of the first program, -1 if the program ends before Address
+	Second      int    // the word of the second program, -1 if the program ends before Address
+	Explanation string // the fields that differ, e.g. "comp D+M vs D+A (a-bit differs)"
+	File        string // the source of the word of the first program, "" without a source map
+	Line        int
+	Label       string // the nearest label at or before Address, "" if there is none
+}
+
+func (mismatch *Mismatch) String() string {
+	var location string = ""
+	if mismatch.File != "" {
+		location = fmt.Sprintf(" (%s:%d", mismatch.File, mismatch.Line)
+		if mismatch.Label != "" {
+			location = location + " in " + mismatch.Label
+		}
+		location = location + ")"
+	}
+	return fmt.Sprintf("addr %d%s: %s", mismatch.Address, location, mismatch.Explanation)
+}
+
+/* Compares the words of two programs address by address and returns the mismatches in the order of
+their addresses. When sourceMap, the source map of the first program, is not nil, every mismatch
+names the source line and the label of the word. */
+func Compare(first []uint16, second []uint16, sourceMap *SourceMap) []*Mismatch {
+	var length int = len(first)
+	if len(second) > length {
+		length = len(second)
+	}
+	var mismatches []*Mismatch
+	for address := 0; address < length; address++ {
+		var mismatch *Mismatch = &Mismatch{Address: address, First: -1, Second: -1}
+		if address < len(first) {
+			mismatch.First = int(first[address])
+		}
+		if address < len(second) {
+			mismatch.Second = int(second[address])
+		}
+		if mismatch.First == mismatch.Second {
+			continue
+		}
+		switch {
+		case mismatch.Second < 0:
+			mismatch.Explanation = fmt.Sprintf("only in the first program: %s", wordText(first[address]))
+		case mismatch.First < 0:
+			mismatch.Explanation = fmt.Sprintf("only in the second program: %s", wordText(second[address]))
+		default:
+			mismatch.Explanation = explainWords(first[address], second[address])
+		}
+		if sourceMap != nil {
+			mismatch.File, mismatch.Line, mismatch.Label, _ = sourceMap.Lookup(address)
+		}
+		mismatches = append(mismatches, mismatch)
+	}
+	return mismatches
+}
+
+// Returns the assembly text of word, e.g. "@17" or "D=D-M"
+func wordText(word uint16) string {
+	if word&0x8000 == 0 {
+		return fmt.Sprintf("@%d", word)
+	}
+	return cCommandText(destText(word), compText(word), jumpText(word))
+}
+
+// Returns the comp mnemonic of a C-instruction word, or its bits if they are not a valid comp
+func compText(word uint16) string {
+	var code string = fmt.Sprintf("%07b", (word>>6)&0x7F)
+	if compMnemonic, ok := decode_comp[code]; ok {
+		return compMnemonic
+	}
+	return "?" + code
+}
+
+func destText(word uint16) string {
+	return decode_dest[fmt.Sprintf("%03b", (word>>3)&0x7)]
+}
+
+func jumpText(word uint16) string {
+	return decode_jump[fmt.Sprintf("%03b", word&0x7)]
+}
+
+// Returns the mnemonic to show for an empty dest or jump
+func orNone(mnemonic string) string {
+	if mnemonic == "" {
+		return "none"
+	}
+	return mnemonic
+}
+
+// Explains how two words that differ differ, field by field
+func explainWords(first uint16, second uint16) string {
+	var firstIsA bool = first&0x8000 == 0
+	var secondIsA bool = second&0x8000 == 0
+	if firstIsA && secondIsA {
+		return fmt.Sprintf("A-instruction value %d vs %d", first, second)
+	} else if firstIsA || secondIsA {
+		return fmt.Sprintf("%s vs %s (%s vs %s)", wordText(first), wordText(second), instructionKind(first), instructionKind(second))
+	}
+
+	var differing uint16 = first ^ second
+	var differences []string
+	if differing&0x6000 != 0 {
+		differences = append(differences, fmt.Sprintf("bits 14-13 %02b vs %02b, which the Hack CPU ignores", (first>>13)&0x3, (second>>13)&0x3))
+	}
+	if differing&0x1FC0 != 0 {
+		differences = append(differences, fmt.Sprintf("comp %s vs %s (%s)", compText(first), compText(second), differingBits(differing, 0, 7)))
+	}
+	if differing&0x0038 != 0 {
+		differences = append(differences, fmt.Sprintf("dest %s vs %s (%s)", orNone(destText(first)), orNone(destText(second)), differingBits(differing, 7, 10)))
+	}
+	if differing&0x0007 != 0 {
+		differences = append(differences, fmt.Sprintf("jump %s vs %s (%s)", orNone(jumpText(first)), orNone(jumpText(second)), differingBits(differing, 10, 13)))
+	}
+	return strings.Join(differences, "; ")
+}
+
+func instructionKind(word uint16) string {
+	if word&0x8000 == 0 {
+		return "A-instruction"
+	}
+	return "C-instruction"
+}
+
+/* Names the bits of differing among c_command_bits[from:to], e.g. "a-bit differs" or
+"bits c1, c4 and c6 differ" */
+func differingBits(differing uint16, from int, to int) string {
+	var names []string
+	for index := from; index < to; index++ {
+		if differing&(1<<uint(12-index)) != 0 {
+			names = append(names, c_command_bits[index])
+		}
+	}
+	if len(names) == 1 {
+		return names[0] + "-bit differs"
+	}
+	return "bits " + strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1] + " differ"
+}
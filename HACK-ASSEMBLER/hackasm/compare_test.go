@@ -0,0 +1,57 @@
+package hackasm
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	var tests = []struct {
+		first    string
+		second   string
+		expected string
+	}{
+		{"@17\n", "@16\n", "addr 0: A-instruction value 17 vs 16"},
+		{"D=D+M\n", "D=D+A\n", "addr 0: comp D+M vs D+A (a-bit differs)"},
+		{"D=D+1\n", "D=D-1\n", "addr 0: comp D+1 vs D-1 (bits c2 and c6 differ)"},
+		{"MD=0;JGT\n", "D=0;JLT\n", "addr 0: dest MD vs D (d3-bit differs); jump JGT vs JLT (bits j1 and j3 differ)"},
+		{"0;JMP\n", "M=0\n", "addr 0: dest none vs M (d3-bit differs); jump JMP vs none (bits j1, j2 and j3 differ)"},
+		{"@5\n", "D=A\n", "addr 0: @5 vs D=A (A-instruction vs C-instruction)"},
+		{"@1\nD=A\n", "@1\n", "addr 1: only in the first program: D=A"},
+		{"@1\n", "@1\nD=A\n", "addr 1: only in the second program: D=A"},
+	}
+	for _, test := range tests {
+		first, _ := mustAssemble(t, test.first, Options{FileName: "First.asm"})
+		second, _ := mustAssemble(t, test.second, Options{FileName: "Second.asm"})
+		var mismatches []*Mismatch = Compare(first, second, nil)
+		if len(mismatches) != 1 {
+			t.Errorf("%q vs %q: %d mismatches, expected 1", test.first, test.second, len(mismatches))
+		} else if got := mismatches[0].String(); got != test.expected {
+			t.Errorf("%q vs %q:\ngot      %s\nexpected %s", test.first, test.second, got, test.expected)
+		}
+	}
+	// Case: the bits 14-13, which the assembler always sets, are cleared in the second word
+	var word uint16 = 0xEC10 // D=A
+	mismatches := Compare([]uint16{word}, []uint16{word &^ 0x6000}, nil)
+	if expected := "addr 0: bits 14-13 11 vs 00, which the Hack CPU ignores"; len(mismatches) != 1 || mismatches[0].String() != expected {
+		t.Errorf("got %v, expected %s", mismatches, expected)
+	}
+}
+
+// With the source map of the first program, every mismatch names the source line and the label of the word
+func TestCompareWithSourceMap(t *testing.T) {
+	var sourceMap bytes.Buffer
+	first, _ := mustAssemble(t, "@0\nD=M\n(LOOP)\n@LOOP\nD;JGT\n", Options{FileName: "First.asm", SourceMap: &sourceMap})
+	second, _ := mustAssemble(t, "@0\nD=M\n(LOOP)\n@LOOP\nD;JGE\n", Options{FileName: "Second.asm"})
+	decoded, err := ReadSourceMap(&sourceMap, "First.map")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mismatches := Compare(first, first, decoded); len(mismatches) != 0 {
+		t.Errorf("a program differs from itself: %v", mismatches)
+	}
+	mismatches := Compare(first, second, decoded)
+	if expected := "addr 3 (First.asm:5 in LOOP): jump JGT vs JGE (j2-bit differs)"; len(mismatches) != 1 || mismatches[0].String() != expected {
+		t.Errorf("got %v, expected %s", mismatches, expected)
+	}
+}
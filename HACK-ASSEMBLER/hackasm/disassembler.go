@@ -0,0 +1,139 @@
+package hackasm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// General description: "Translates Hack machine code back into Hack assembly language mnemonics."
+var decode_dest map[string]string = invert(code_dest)
+var decode_comp map[string]string = invert(code_comp)
+var decode_jump map[string]string = invert(code_jump)
+
+// Returns the table that maps each binary code of table back to its mnemonic
+func invert(table map[string]string) map[string]string {
+	var inverse map[string]string = map[string]string{}
+	for mnemonic, code := range table {
+		inverse[code] = mnemonic
+	}
+	return inverse
+}
+
+// Reads the 16-bit words of a .hack file, one binary word per line
+func ReadHack(reader io.Reader, fileName string) ([]uint16, error) {
+	var words []uint16
+	var errors ErrorList
+	scanner := bufio.NewScanner(reader)
+	var lineNumber int = 0
+	for scanner.Scan() {
+		lineNumber = lineNumber + 1
+		var line string = strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		word, err := strconv.ParseUint(line, 2, 16)
+		if err != nil || len(line) != 16 {
+			errors = append(errors, &SourceError{File: fileName, Line: lineNumber, Column: 1, Message: fmt.Sprintf("'%s' is not a 16-bit binary word", line)})
+			continue
+		}
+		words = append(words, uint16(word))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(errors) > 0 {
+		return nil, errors
+	}
+	return words, nil
+}
+
+// Returns the dest, comp and jump mnemonics of a C-instruction word, or ok = false if word is not a valid C-instruction
+func decodeCCommand(word uint16) (destMnemonic string, compMnemonic string, jumpMnemonic string, ok bool) {
+	if word&0xE000 != 0xE000 {
+		return "", "", "", false
+	}
+	compMnemonic, ok = decode_comp[fmt.Sprintf("%07b", (word>>6)&0x7F)]
+	destMnemonic = decode_dest[fmt.Sprintf("%03b", (word>>3)&0x7)]
+	jumpMnemonic = decode_jump[fmt.Sprintf("%03b", word&0x7)]
+	return destMnemonic, compMnemonic, jumpMnemonic, ok
+}
+
+// Returns the assembly text of a C-instruction, e.g. "D=D-M" or "D;JGT"
+func cCommandText(destMnemonic string, compMnemonic string, jumpMnemonic string) string {
+	var command string = compMnemonic
+	if destMnemonic != "" {
+		command = destMnemonic + "=" + command
+	}
+	if jumpMnemonic != "" {
+		command = command + ";" + jumpMnemonic
+	}
+	return command
+}
+
+/* Returns the assembly text of the A-instruction at address, which loads value.
+Jump targets become the synthesized labels, SCREEN and KBD are always named, and
+the addresses 0-15 are named R0-R15 (or SP, LCL, ARG, THIS and THAT with vmNames)
+when the next instruction uses them to access the memory. */
+func aCommandText(words []uint16, address int, labels map[int]bool, vmNames bool) string {
+	var value int = int(words[address])
+	var nextDest, nextComp, nextJump string
+	var nextIsC bool = false
+	if address+1 < len(words) {
+		nextDest, nextComp, nextJump, nextIsC = decodeCCommand(words[address+1])
+	}
+
+	if nextIsC && nextJump != "" && labels[value] {
+		return fmt.Sprintf("@L_%04d", value)
+	}
+	switch value {
+	case 16384:
+		return "@SCREEN"
+	case 24576:
+		return "@KBD"
+	}
+	var accessesMemory bool = nextIsC && (strings.Contains(nextComp, "M") || strings.Contains(nextDest, "M"))
+	if value <= 15 && accessesMemory {
+		var vmSymbols []string = []string{"SP", "LCL", "ARG", "THIS", "THAT"}
+		if vmNames && value < len(vmSymbols) {
+			return "@" + vmSymbols[value]
+		}
+		return "@R" + strconv.Itoa(value)
+	}
+	return "@" + strconv.Itoa(value)
+}
+
+/* Translates Hack machine code back into assembly. An A-instruction followed by a jump
+marks its value as a jump target, which gets a label (L_0042) at that ROM address.
+Words that are not valid instructions are kept as raw-data comments. */
+func Disassemble(words []uint16, vmNames bool) string {
+	var labels map[int]bool = map[int]bool{}
+	for address := 0; address+1 < len(words); address++ {
+		_, _, jumpMnemonic, isC := decodeCCommand(words[address+1])
+		if words[address]&0x8000 == 0 && isC && jumpMnemonic != "" && int(words[address]) <= len(words) {
+			labels[int(words[address])] = true
+		}
+	}
+
+	var builder strings.Builder
+	for address, word := range words {
+		if labels[address] {
+			fmt.Fprintf(&builder, "(L_%04d)\n", address)
+		}
+		var command string
+		if word&0x8000 == 0 {
+			command = aCommandText(words, address, labels, vmNames)
+		} else if destMnemonic, compMnemonic, jumpMnemonic, ok := decodeCCommand(word); ok {
+			command = cCommandText(destMnemonic, compMnemonic, jumpMnemonic)
+		} else {
+			command = fmt.Sprintf("// raw data 0x%04X", word)
+		}
+		fmt.Fprintf(&builder, "    %-24s// %d\n", command, address)
+	}
+	if labels[len(words)] { // Case: a jump to the end of the program
+		fmt.Fprintf(&builder, "(L_%04d)\n", len(words))
+	}
+	return builder.String()
+}
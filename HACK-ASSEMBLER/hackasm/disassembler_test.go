@@ -0,0 +1,59 @@
+package hackasm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// Jump targets get labels, R0-R15 are named when the next instruction accesses the memory, and invalid words are kept as comments
+func TestDisassemble(t *testing.T) {
+	words, _ := mustAssemble(t, "@0\nM=M+1\n(LOOP)\n@SCREEN\nD=A\n@LOOP\nD;JGT\n@1\nD=A\n@END\n0;JMP\n(END)\n", Options{FileName: "Loop.asm"})
+	words = append(words, 0x8000)
+	var expected string = "    @R0                     // 0\n" +
+		"    M=M+1                   // 1\n" +
+		"(L_0002)\n" +
+		"    @SCREEN                 // 2\n" +
+		"    D=A                     // 3\n" +
+		"    @L_0002                 // 4\n" +
+		"    D;JGT                   // 5\n" +
+		"    @1                      // 6\n" +
+		"    D=A                     // 7\n" +
+		"    @L_0010                 // 8\n" +
+		"    0;JMP                   // 9\n" +
+		"(L_0010)\n" +
+		"    // raw data 0x8000      // 10\n"
+	if got := Disassemble(words, false); got != expected {
+		t.Errorf("got\n%s\nexpected\n%s", got, expected)
+	}
+	if got := Disassemble(words, true); got != strings.Replace(expected, "@R0 ", "@SP ", 1) {
+		t.Errorf("with the VM names, got\n%s", got)
+	}
+}
+
+func TestReadHackErrors(t *testing.T) {
+	_, err := ReadHack(strings.NewReader("0000000000000010\n\n111011000001000\n1110110000010002\n"), "Bad.hack")
+	errorList, ok := err.(ErrorList)
+	if ok == false || len(errorList) != 2 {
+		t.Fatalf("expected 2 errors, got %v", err)
+	}
+	if errorList[0].Error() != "Bad.hack:3:1: '111011000001000' is not a 16-bit binary word" || errorList[1].Line != 4 {
+		t.Errorf("got %v", errorList)
+	}
+}
+
+// ReadHack() reads back the words written in the .hack format, and Disassemble() turns them into source that assembles into the same words
+func TestDisassembleRoundTrip(t *testing.T) {
+	for _, name := range asmFiles {
+		t.Run(name, func(t *testing.T) {
+			words, err1 := ReadHack(bytes.NewReader(readAsmFile(t, name, ".hack")), name+".hack")
+			if err1 != nil {
+				t.Fatal(err1)
+			}
+			reassembled, _ := mustAssemble(t, Disassemble(words, false), Options{FileName: name + ".dis.asm"})
+			if hackText(t, reassembled) != hackText(t, words) {
+				t.Errorf("the disassembly of %s.hack does not assemble into the same words", name)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package hackasm
+
+import (
+	"fmt"
+	"strings"
+)
+
+/* An error in the assembly source, reported as "file.asm:line:column: message", or as
+"file.asm:line: message" when the column is unknown (0) */
+type SourceError struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (err *SourceError) Error() string {
+	if err.Column == 0 {
+		return fmt.Sprintf("%s:%d: %s", err.File, err.Line, err.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", err.File, err.Line, err.Column, err.Message)
+}
+
+// Every error found in one file, in source order
+type ErrorList []*SourceError
+
+func (list ErrorList) Error() string {
+	var messages []string
+	for _, err := range list {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "\n")
+}
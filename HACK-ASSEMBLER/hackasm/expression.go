@@ -0,0 +1,261 @@
+package hackasm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// General description: "Evaluates the integer expressions of A-instructions and .equ directives."
+type expressionError struct {
+	offset  int // offset of the error within the expression
+	message string
+}
+
+type expressionParser struct {
+	text      string
+	offset    int
+	lookup    func(name string) (int, bool)
+	err       *expressionError
+	undefined *expressionError // the first name that lookup does not know, reported only if the expression is well-formed
+}
+
+// Binary operators from the lowest to the highest precedence
+var expressionOperators = [][]string{{"|"}, {"^"}, {"&"}, {"<<", ">>"}, {"+", "-"}, {"*", "/", "%"}}
+
+/* Evaluates an expression of numbers, character literals and names, combined by the operators
+| ^ & << >> + - * / %, unary - + and ~, and parentheses. lookup returns the value of a name.
+Numbers are decimal (42), hexadecimal (0x2A) or binary (0b101010). ~ complements the 15 bits
+that an A-instruction can load. */
+func evaluate(text string, lookup func(name string) (int, bool)) (int, *expressionError) {
+	var expressionparser *expressionParser = &expressionParser{text: text, lookup: lookup}
+	var value int64 = expressionparser.parseBinary(0)
+	expressionparser.skipSpaces()
+	if expressionparser.err == nil && expressionparser.offset < len(text) {
+		var token string = symbolPrefix(text[expressionparser.offset:])
+		if token == "" {
+			token = text[expressionparser.offset : expressionparser.offset+1]
+		}
+		expressionparser.fail(expressionparser.offset, "unexpected '%s' in expression", token)
+	}
+	if expressionparser.err == nil {
+		expressionparser.err = expressionparser.undefined
+	}
+	return int(value), expressionparser.err
+}
+
+// Returns the names that the expression refers to, in order
+func expressionNames(text string) []string {
+	var names []string
+	evaluate(text, func(name string) (int, bool) {
+		names = append(names, name)
+		return 1, true // not 0, so that a division does not end the evaluation
+	})
+	return names
+}
+
+// Records the first error of the expression
+func (expressionparser *expressionParser) fail(offset int, format string, args ...interface{}) {
+	if expressionparser.err == nil {
+		expressionparser.err = &expressionError{offset: offset, message: fmt.Sprintf(format, args...)}
+	}
+}
+
+func (expressionparser *expressionParser) skipSpaces() {
+	for expressionparser.offset < len(expressionparser.text) && strings.ContainsRune(" \t", rune(expressionparser.text[expressionparser.offset])) {
+		expressionparser.offset = expressionparser.offset + 1
+	}
+}
+
+// Parses the operands joined by the operators of the given precedence level or higher
+func (expressionparser *expressionParser) parseBinary(level int) int64 {
+	if level == len(expressionOperators) {
+		return expressionparser.parseUnary()
+	}
+	var left int64 = expressionparser.parseBinary(level + 1)
+	for expressionparser.err == nil {
+		expressionparser.skipSpaces()
+		var operator string
+		for _, candidate := range expressionOperators[level] {
+			if strings.HasPrefix(expressionparser.text[expressionparser.offset:], candidate) {
+				operator = candidate
+			}
+		}
+		if operator == "" {
+			break
+		}
+		var offset int = expressionparser.offset
+		expressionparser.offset = expressionparser.offset + len(operator)
+		var right int64 = expressionparser.parseBinary(level + 1)
+		left = expressionparser.apply(operator, left, right, offset)
+	}
+	return left
+}
+
+func (expressionparser *expressionParser) apply(operator string, left int64, right int64, offset int) int64 {
+	var value int64
+	switch operator {
+	case "|":
+		value = left | right
+	case "^":
+		value = left ^ right
+	case "&":
+		value = left & right
+	case "<<", ">>":
+		if right < 0 || right > 15 {
+			expressionparser.fail(offset, "shift count %d out of range", right)
+			return 0
+		}
+		if operator == "<<" {
+			value = left << uint(right)
+		} else {
+			value = left >> uint(right)
+		}
+	case "+":
+		value = left + right
+	case "-":
+		value = left - right
+	case "*":
+		value = left * right
+	case "/", "%":
+		if right == 0 {
+			expressionparser.fail(offset, "division by zero")
+			return 0
+		}
+		if operator == "/" {
+			value = left / right
+		} else {
+			value = left % right
+		}
+	}
+	if value > 1<<31 || value < -(1<<31) {
+		expressionparser.fail(offset, "expression overflows")
+		return 0
+	}
+	return value
+}
+
+func (expressionparser *expressionParser) parseUnary() int64 {
+	expressionparser.skipSpaces()
+	if expressionparser.offset < len(expressionparser.text) {
+		switch expressionparser.text[expressionparser.offset] {
+		case '-':
+			expressionparser.offset = expressionparser.offset + 1
+			return -expressionparser.parseUnary()
+		case '+':
+			expressionparser.offset = expressionparser.offset + 1
+			return expressionparser.parseUnary()
+		case '~':
+			expressionparser.offset = expressionparser.offset + 1
+			return ^expressionparser.parseUnary() & 0x7FFF
+		}
+	}
+	return expressionparser.parsePrimary()
+}
+
+// Character codes of the Hack keyboard beyond ASCII, named in character literals such as 'LEFT'
+var hack_keys = map[string]int64{
+	"NEWLINE": 128, "BACKSPACE": 129, "LEFT": 130, "UP": 131, "RIGHT": 132, "DOWN": 133, "HOME": 134,
+	"END": 135, "PAGEUP": 136, "PAGEDOWN": 137, "INSERT": 138, "DELETE": 139, "ESC": 140,
+	"F1": 141, "F2": 142, "F3": 143, "F4": 144, "F5": 145, "F6": 146,
+	"F7": 147, "F8": 148, "F9": 149, "F10": 150, "F11": 151, "F12": 152,
+}
+
+/* Returns the Hack character code of the contents of a character literal: a printable ASCII
+character ('A'), an escape ('\n' for newline, '\b' for backspace, '\'' and '\\') or the name of
+a special key ('LEFT', 'F1'). */
+func characterCode(contents string) (int64, bool) {
+	switch {
+	case len(contents) == 1 && contents[0] >= 32 && contents[0] <= 126 && contents[0] != '\\':
+		return int64(contents[0]), true
+	case contents == "\\n":
+		return hack_keys["NEWLINE"], true
+	case contents == "\\b":
+		return hack_keys["BACKSPACE"], true
+	case contents == "\\'" || contents == "\\\\":
+		return int64(contents[1]), true
+	}
+	code, ok := hack_keys[strings.ToUpper(contents)]
+	return code, ok
+}
+
+// Returns the value of a numeric literal: decimal, hexadecimal with 0x or binary with 0b
+func parseNumber(token string) (int64, bool) {
+	var digits string = token
+	var base int = 10
+	if len(token) > 2 && token[0] == '0' && (token[1] == 'x' || token[1] == 'X') {
+		digits, base = token[2:], 16
+	} else if len(token) > 2 && token[0] == '0' && (token[1] == 'b' || token[1] == 'B') {
+		digits, base = token[2:], 2
+	}
+	value, err := strconv.ParseInt(digits, base, 64)
+	if err != nil || value > 1<<31 {
+		return 0, false
+	}
+	return value, true
+}
+
+// Parses a number, a character literal, a name or a parenthesized expression
+func (expressionparser *expressionParser) parsePrimary() int64 {
+	var text string = expressionparser.text
+	var start int = expressionparser.offset
+	if start == len(text) {
+		expressionparser.fail(start, "missing operand at the end of the expression")
+		return 0
+	}
+	if text[start] == '(' {
+		expressionparser.offset = expressionparser.offset + 1
+		var value int64 = expressionparser.parseBinary(0)
+		expressionparser.skipSpaces()
+		if expressionparser.offset >= len(text) || text[expressionparser.offset] != ')' {
+			expressionparser.fail(start, "unbalanced '(' in expression")
+			return 0
+		}
+		expressionparser.offset = expressionparser.offset + 1
+		return value
+	}
+	if text[start] == '\'' {
+		var from int = start + 1
+		if from < len(text) && text[from] == '\\' {
+			from = from + 2 // the escaped character may be a quote
+		}
+		var end int = -1
+		if from <= len(text) && strings.IndexByte(text[from:], '\'') >= 0 {
+			end = from + strings.IndexByte(text[from:], '\'')
+		}
+		if end < 0 {
+			expressionparser.fail(start, "unterminated character literal")
+			return 0
+		}
+		expressionparser.offset = end + 1
+		code, ok := characterCode(text[start+1 : end])
+		if ok == false {
+			expressionparser.fail(start, "unknown character %s", text[start:end+1])
+			return 0
+		}
+		return code
+	}
+
+	var token string = symbolPrefix(text[start:])
+	if token == "" {
+		expressionparser.fail(start, "unexpected '%c' in expression", text[start])
+		return 0
+	}
+	expressionparser.offset = expressionparser.offset + len(token)
+	if token[0] >= '0' && token[0] <= '9' { // Case: anything that starts with a digit must be a well-formed number
+		value, ok := parseNumber(token)
+		if ok == false {
+			expressionparser.fail(start, "malformed number '%s'", token)
+			return 0
+		}
+		return value
+	}
+	value, ok := expressionparser.lookup(token)
+	if ok == false {
+		if expressionparser.undefined == nil {
+			expressionparser.undefined = &expressionError{offset: start, message: fmt.Sprintf("undefined name '%s'", token)}
+		}
+		return 1 // not 0, so that the rest of the expression is checked without a division by zero
+	}
+	return int64(value)
+}
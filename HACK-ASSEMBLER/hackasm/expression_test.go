@@ -0,0 +1,43 @@
+package hackasm
+
+import "testing"
+
+func TestEvaluate(t *testing.T) {
+	var names map[string]int = map[string]int{"a": 3, "SCREEN": 16384}
+	var lookup = func(name string) (int, bool) {
+		value, ok := names[name]
+		return value, ok
+	}
+	var tests = []struct {
+		text    string
+		value   int
+		message string // "" if the expression is valid
+		offset  int
+	}{
+		{"1+2*3", 7, "", 0},
+		{"(1+2)*3", 9, "", 0},
+		{"SCREEN + a*32", 16480, "", 0},
+		{"1<<4 | 0b11", 19, "", 0},
+		{"-a", -3, "", 0},
+		{"~0", 0x7FFF, "", 0},
+		{"'A'+1", 66, "", 0},
+		{"b", 0, "undefined name 'b'", 0},
+		{"a+b*2", 0, "undefined name 'b'", 2},
+		{"a b", 0, "unexpected 'b' in expression", 2},
+		{"b c", 0, "unexpected 'c' in expression", 2},
+		{"b/0", 0, "division by zero", 1},
+		{"(1+2", 0, "unbalanced '(' in expression", 0},
+		{"1+", 0, "missing operand at the end of the expression", 2},
+		{"0x1G", 0, "malformed number '0x1G'", 0},
+		{"1 << 16", 0, "shift count 16 out of range", 2},
+		{"'QQ'", 0, "unknown character 'QQ'", 0},
+	}
+	for _, test := range tests {
+		value, err := evaluate(test.text, lookup)
+		if test.message == "" && (err != nil || value != test.value) {
+			t.Errorf("%q = %d, %v; expected %d", test.text, value, err, test.value)
+		} else if test.message != "" && (err == nil || err.message != test.message || err.offset != test.offset) {
+			t.Errorf("%q: got error %+v, expected %q at offset %d", test.text, err, test.message, test.offset)
+		}
+	}
+}
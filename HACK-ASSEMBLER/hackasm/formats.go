@@ -0,0 +1,151 @@
+package hackasm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+)
+
+// General description: "Encodes the assembled words in the formats that ROMs, simulators and other tools load."
+type outputFormat struct {
+	ext    string // extension of the output file
+	encode func(writer *bufio.Writer, words []uint16, fileName string)
+}
+
+var output_formats = map[string]outputFormat{
+	"hack":    {".hack", writeHackText},
+	"bin":     {".bin", writeBinary},
+	"ihex":    {".hex", writeIntelHex},
+	"logisim": {".rom", writeLogisim},
+	"memb":    {".memb", writeReadmemb},
+	"memh":    {".memh", writeReadmemh},
+	"go":      {".go", writeGoArray},
+	"c":       {".h", writeCArray},
+}
+
+// Returns the names of the output formats, in alphabetical order
+func OutputFormatNames() []string {
+	var names []string
+	for name := range output_formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns the extension of the files written in the given output format, e.g. ".hex" for "ihex"
+func FormatExtension(format string) (string, bool) {
+	outputformat, ok := output_formats[format]
+	return outputformat.ext, ok
+}
+
+// Encodes words in the given format and writes them to writer
+func EncodeWords(writer io.Writer, words []uint16, format string, fileName string) error {
+	outputformat, ok := output_formats[format]
+	if ok == false {
+		return fmt.Errorf("unknown output format '%s'", format)
+	}
+	bufferedWriter := bufio.NewWriter(writer)
+	outputformat.encode(bufferedWriter, words, fileName)
+	return bufferedWriter.Flush()
+}
+
+// The .hack text format: one 16-bit word per line, written in binary
+func writeHackText(writer *bufio.Writer, words []uint16, fileName string) {
+	var line [17]byte
+	line[16] = '\n'
+	for _, word := range words {
+		for bit := 0; bit < 16; bit++ {
+			line[bit] = '0' + byte(word>>uint(15-bit)&1)
+		}
+		writer.Write(line[:])
+	}
+}
+
+// A raw binary image: two bytes per word, the most significant byte first
+func writeBinary(writer *bufio.Writer, words []uint16, fileName string) {
+	var buffer [2]byte
+	for _, word := range words {
+		binary.BigEndian.PutUint16(buffer[:], word)
+		writer.Write(buffer[:])
+	}
+}
+
+/* Intel HEX: data records of up to 16 bytes, addressed in bytes with two bytes per word,
+the most significant byte first, followed by the end-of-file record. The 32K-word ROM fits
+in the 16-bit addresses of the records. */
+func writeIntelHex(writer *bufio.Writer, words []uint16, fileName string) {
+	const wordsPerRecord = 8
+	for start := 0; start < len(words); start += wordsPerRecord {
+		var end int = start + wordsPerRecord
+		if end > len(words) {
+			end = len(words)
+		}
+		var byteAddress int = start * 2
+		var record []byte = []byte{byte((end - start) * 2), byte(byteAddress >> 8), byte(byteAddress), 0x00}
+		for _, word := range words[start:end] {
+			record = append(record, byte(word>>8), byte(word))
+		}
+		var checksum byte = 0
+		for _, b := range record {
+			checksum = checksum + b
+		}
+		record = append(record, -checksum)
+		fmt.Fprintf(writer, ":%X\n", record)
+	}
+	writer.WriteString(":00000001FF\n")
+}
+
+// A Logisim ROM image: the "v2.0 raw" header, then the words in hexadecimal, eight per line
+func writeLogisim(writer *bufio.Writer, words []uint16, fileName string) {
+	writer.WriteString("v2.0 raw\n")
+	writeWordLines(writer, words, 8, "", "%x", " ")
+}
+
+// A memory file for Verilog's $readmemb: one word per line, written in binary
+func writeReadmemb(writer *bufio.Writer, words []uint16, fileName string) {
+	fmt.Fprintf(writer, "// %s, %d words, for $readmemb\n", filepath.Base(fileName), len(words))
+	writeWordLines(writer, words, 1, "", "%016b", "")
+}
+
+// A memory file for Verilog's $readmemh: one word per line, written in hexadecimal
+func writeReadmemh(writer *bufio.Writer, words []uint16, fileName string) {
+	fmt.Fprintf(writer, "// %s, %d words, for $readmemh\n", filepath.Base(fileName), len(words))
+	writeWordLines(writer, words, 1, "", "%04x", "")
+}
+
+// Go source that declares the words as the array rom in package rom
+func writeGoArray(writer *bufio.Writer, words []uint16, fileName string) {
+	fmt.Fprintf(writer, "// Code generated by hackassembler from %s. DO NOT EDIT.\n\n", filepath.Base(fileName))
+	writer.WriteString("package rom\n\n")
+	writer.WriteString("var rom = [...]uint16{\n")
+	writeWordLines(writer, words, 8, "\t", "0x%04x,", " ")
+	writer.WriteString("}\n")
+}
+
+// A C header that declares the words as the array rom
+func writeCArray(writer *bufio.Writer, words []uint16, fileName string) {
+	fmt.Fprintf(writer, "/* Generated by hackassembler from %s. */\n", filepath.Base(fileName))
+	writer.WriteString("#include <stdint.h>\n\n")
+	fmt.Fprintf(writer, "static const uint16_t rom[%d] = {\n", len(words))
+	writeWordLines(writer, words, 8, "\t", "0x%04x,", " ")
+	writer.WriteString("};\n")
+}
+
+// Writes each word with the given format, perLine words per line that starts with indent, joined by separator
+func writeWordLines(writer *bufio.Writer, words []uint16, perLine int, indent string, format string, separator string) {
+	for i, word := range words {
+		if i%perLine == 0 {
+			writer.WriteString(indent)
+		} else {
+			writer.WriteString(separator)
+		}
+		fmt.Fprintf(writer, format, word)
+		if i%perLine == perLine-1 || i == len(words)-1 {
+			writer.WriteString("\n")
+		}
+	}
+}
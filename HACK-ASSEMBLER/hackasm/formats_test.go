@@ -0,0 +1,76 @@
+package hackasm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEncodeWords(t *testing.T) {
+	var words []uint16 = []uint16{0x0002, 0xEC10, 0x0003, 0xE090, 0x0000, 0xE308, 0x7FFF, 0xEA87, 0x4000}
+	var tests = []struct {
+		format   string
+		expected string
+	}{
+		{"hack", "0000000000000010\n1110110000010000\n0000000000000011\n1110000010010000\n0000000000000000\n1110001100001000\n0111111111111111\n1110101010000111\n0100000000000000\n"},
+		{"bin", "\x00\x02\xEC\x10\x00\x03\xE0\x90\x00\x00\xE3\x08\x7F\xFF\xEA\x87\x40\x00"},
+		{"ihex", ":100000000002EC100003E0900000E3087FFFEA87A5\n:020010004000AE\n:00000001FF\n"},
+		{"logisim", "v2.0 raw\n2 ec10 3 e090 0 e308 7fff ea87\n4000\n"},
+		{"memb", "// Add.asm, 9 words, for $readmemb\n0000000000000010\n1110110000010000\n0000000000000011\n1110000010010000\n0000000000000000\n1110001100001000\n0111111111111111\n1110101010000111\n0100000000000000\n"},
+		{"memh", "// Add.asm, 9 words, for $readmemh\n0002\nec10\n0003\ne090\n0000\ne308\n7fff\nea87\n4000\n"},
+		{"go", "// Code generated by hackassembler from Add.asm. DO NOT EDIT.\n\npackage rom\n\nvar rom = [...]uint16{\n\t0x0002, 0xec10, 0x0003, 0xe090, 0x0000, 0xe308, 0x7fff, 0xea87,\n\t0x4000,\n}\n"},
+		{"c", "/* Generated by hackassembler from Add.asm. */\n#include <stdint.h>\n\nstatic const uint16_t rom[9] = {\n\t0x0002, 0xec10, 0x0003, 0xe090, 0x0000, 0xe308, 0x7fff, 0xea87,\n\t0x4000,\n};\n"},
+	}
+	for _, test := range tests {
+		var output bytes.Buffer
+		if err := EncodeWords(&output, words, test.format, "asm_files/Add.asm"); err != nil {
+			t.Errorf("%s: %v", test.format, err)
+			continue
+		}
+		if output.String() != test.expected {
+			t.Errorf("%s:\ngot      %q\nexpected %q", test.format, output.String(), test.expected)
+		}
+	}
+	if len(tests) != len(OutputFormatNames()) {
+		t.Errorf("%d formats tested, but there are %d", len(tests), len(OutputFormatNames()))
+	}
+}
+
+// Every record of Intel HEX sums to 0 modulo 256, and the data records hold the words big-endian
+func TestEncodeIntelHexChecksums(t *testing.T) {
+	words, _ := mustAssemble(t, string(readAsmFile(t, "Max", ".asm")), Options{FileName: "Max.asm"})
+	var output bytes.Buffer
+	if err := EncodeWords(&output, words, "ihex", "Max.asm"); err != nil {
+		t.Fatal(err)
+	}
+	var decoded []uint16
+	for _, record := range strings.Fields(output.String()) {
+		var sum byte = 0
+		var data []byte
+		for i := 1; i+1 < len(record); i = i + 2 {
+			var value byte
+			for _, digit := range record[i : i+2] {
+				value = value<<4 | byte(strings.IndexRune("0123456789ABCDEF", digit))
+			}
+			sum = sum + value
+			data = append(data, value)
+		}
+		if sum != 0 {
+			t.Errorf("record %s does not sum to 0", record)
+		}
+		if data[3] == 0x00 {
+			for i := 4; i+1 < len(data)-1; i = i + 2 {
+				decoded = append(decoded, uint16(data[i])<<8|uint16(data[i+1]))
+			}
+		}
+	}
+	if hackText(t, decoded) != hackText(t, words) {
+		t.Errorf("the Intel HEX records do not hold the words of Max.asm")
+	}
+}
+
+func TestEncodeUnknownFormat(t *testing.T) {
+	if err := EncodeWords(&bytes.Buffer{}, nil, "srec", "Add.asm"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,319 @@
+package hackasm
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/* General description: "Rewrites a program in the canonical layout without changing its machine
+code." Labels, directives, .macro and .endm start in the first column, while instructions,
+pseudo-instructions and macro calls are indented by four spaces, the operands of a pseudo-instruction
+separated by single spaces. The trailing comments of a block of lines are aligned, a comment
+on a line of its own is indented like the command right below it, and runs of blank lines shrink to
+one. C-instructions lose their spaces and, where the instruction set only accepts one spelling, are
+written the way its tables spell them, e.g. MD=D+M for DM = M+D. The lines that hold a block comment
+are left as they are. */
+
+const formatIndent = "    "
+
+// A line of the formatted program: its command and its comment, both empty for a blank line
+type formattedLine struct {
+	indented bool
+	code     string
+	comment  string
+	verbatim bool // whether the line touches a block comment and is written as it is, held in comment
+}
+
+// Matches the labels that end in a number, such as L_0042 or WHILE3, and splits off the number
+var numberedLabel = regexp.MustCompile(`^(.*[^0-9])([0-9]+)$`)
+
+/* Formats the program read from reader and returns the formatted source. With renumberLabels set,
+the labels that end in a number are renumbered from 0 in the order they are declared, separately
+for every prefix, so L_0042, L_0007 and L_0100 become L_0000, L_0001 and L_0002. Labels named by
+.export or .import, local labels and renamings that would clash with another symbol are left alone. The program
+is assembled before and after formatting; its errors are returned if it is invalid, and an error
+is returned if the formatted program would assemble differently. */
+func Format(reader io.Reader, options Options, renumberLabels bool) ([]byte, error) {
+	source, err1 := ioutil.ReadAll(reader) // the input is read once, and formatted and assembled from memory
+	if err1 != nil {
+		return nil, err1
+	}
+	var formatOptions Options = options
+	formatOptions.Optimize = false
+	formatOptions.Listing = nil
+	formatOptions.SourceMap = nil
+	formatOptions.Optimizations = nil
+	assembled, words, _, err2 := assemble(bytes.NewReader(source), formatOptions, true, 0, nil)
+	if err2 != nil {
+		return nil, err2
+	}
+
+	var lines []formattedLine
+	var macros map[string]bool = map[string]bool{}
+	var inMacro bool = false
+	var linked map[string]bool = map[string]bool{} // the names of .export and .import
+	var labels []string                            // the labels declared outside the macros, in order
+	var inComment bool = false                     // whether a block comment is open
+	scanner := bufio.NewScanner(bytes.NewReader(source))
+	for scanner.Scan() {
+		var text string = strings.TrimSuffix(scanner.Text(), "\r")
+		if len(lines) == 0 {
+			text = strings.TrimPrefix(text, byteOrderMark)
+		}
+		var line formattedLine
+		var written string = text
+		var wasInComment bool = inComment
+		text, inComment, _ = stripBlockComments(text, inComment)
+		if wasInComment || text != written {
+			line.verbatim = true
+		}
+		if comment := strings.Index(text, "//"); comment >= 0 {
+			line.comment = strings.TrimRight(text[comment:], " \t\r")
+			text = text[:comment]
+		}
+		line.code = strings.TrimSpace(text)
+		var fields []string = strings.Fields(line.code)
+		switch {
+		case line.code == "":
+		case fields[0] == ".macro":
+			inMacro = true
+			if header := strings.Fields(strings.Replace(strings.Join(fields[1:], " "), ",", " ", -1)); len(header) > 0 {
+				macros[header[0]] = true
+			}
+		case fields[0] == ".endm":
+			inMacro = false
+		case fields[0] == ".export" || fields[0] == ".import":
+			for _, name := range strings.Split(strings.Join(fields[1:], ""), ",") {
+				linked[name] = true
+			}
+		case strings.HasPrefix(line.code, "("):
+			if label := strings.TrimSuffix(line.code[1:], ")"); inMacro == false && strings.HasSuffix(line.code, ")") && strings.HasPrefix(label, ".") == false {
+				labels = append(labels, label) // local labels keep their names, which only need to be unique within a scope
+			}
+		case strings.HasPrefix(line.code, "@"):
+			line.indented = true
+		case strings.HasPrefix(line.code, "."):
+		case macros[fields[0]]:
+			line.indented = true
+		case assembled.pseudo && isPseudoInstruction(line.code):
+			line.indented = true
+			name, operands := pseudoOperands(line.code)
+			line.code = strings.Join(append([]string{name}, operands...), " ")
+		default:
+			line.indented = true
+			line.code = canonicalCommand(strings.Join(strings.Fields(line.code), ""), assembled.instructionset, assembled.aliases)
+		}
+		if line.verbatim {
+			line = formattedLine{comment: strings.TrimRight(written, " \t"), verbatim: true}
+		}
+		lines = append(lines, line)
+	}
+	if err3 := scanner.Err(); err3 != nil {
+		return nil, err3
+	}
+
+	if renumberLabels {
+		var renamed map[string]string = renumberedLabels(lines, labels, linked)
+		rename := func(name string) string {
+			if newName, ok := renamed[name]; ok {
+				return newName
+			}
+			return name
+		}
+		for index := range lines {
+			if strings.HasPrefix(lines[index].code, ".include") {
+				continue // the path of an included file is not a symbol
+			} else if lines[index].verbatim {
+				lines[index].comment = replaceSymbols(lines[index].comment, rename)
+				continue
+			}
+			lines[index].code = replaceSymbols(lines[index].code, rename)
+		}
+	}
+
+	var formatted []byte = writeFormatted(lines)
+	_, formattedWords, _, err4 := assemble(bytes.NewReader(formatted), formatOptions, true, 0, nil)
+	if err4 != nil {
+		return nil, fmt.Errorf("%s: the formatted program does not assemble: %v", assembled.fileName, err4)
+	}
+	if len(formattedWords) != len(words) {
+		return nil, fmt.Errorf("%s: formatting would change the length of the machine code from %d to %d words", assembled.fileName, len(words), len(formattedWords))
+	}
+	for address := range words {
+		if formattedWords[address] != words[address] {
+			return nil, fmt.Errorf("%s: formatting would change the word at ROM address %d", assembled.fileName, address)
+		}
+	}
+	return formatted, nil
+}
+
+// Returns the C-instruction command, written without spaces, in the spelling of the instruction set
+func canonicalCommand(command string, instructionset *InstructionSet, aliases bool) string {
+	var scratch Parser = Parser{currentCommand: command}
+	destMnemonic, compMnemonic, jumpMnemonic := scratch.Dest(), scratch.Comp(), scratch.Jump()
+	if aliases {
+		destMnemonic, compMnemonic = instructionset.canonical(destMnemonic, compMnemonic)
+	}
+	if destMnemonic != "" {
+		compMnemonic = destMnemonic + "=" + compMnemonic
+	}
+	if jumpMnemonic != "" {
+		compMnemonic = compMnemonic + ";" + jumpMnemonic
+	}
+	return compMnemonic
+}
+
+// Returns the new names of the numbered labels, which are renumbered in the order of labels
+func renumberedLabels(lines []formattedLine, labels []string, linked map[string]bool) map[string]string {
+	var used map[string]bool = map[string]bool{} // every name in the program
+	for _, line := range lines {
+		replaceSymbols(line.code, func(name string) string {
+			used[name] = true
+			return name
+		})
+	}
+	var prefixes []string
+	var groups map[string][]string = map[string][]string{}
+	for _, label := range labels {
+		var match []string = numberedLabel.FindStringSubmatch(label)
+		if match == nil || linked[label] {
+			continue
+		}
+		if _, ok := groups[match[1]]; ok == false {
+			prefixes = append(prefixes, match[1])
+		}
+		groups[match[1]] = append(groups[match[1]], label)
+	}
+
+	var renamed map[string]string = map[string]string{}
+	for _, prefix := range prefixes {
+		var group []string = groups[prefix]
+		var width int = len(group[0]) - len(prefix)
+		var names map[string]string = map[string]string{}
+		var clashes bool = false
+		for index, label := range group {
+			var number string = strconv.Itoa(index)
+			for len(number) < width {
+				number = "0" + number
+			}
+			names[label] = prefix + number
+		}
+		for _, newName := range names {
+			if _, isOldName := names[newName]; used[newName] && isOldName == false {
+				clashes = true
+			}
+		}
+		if clashes == false {
+			for label, newName := range names {
+				renamed[label] = newName
+			}
+		}
+	}
+	return renamed
+}
+
+/* Returns code with every symbol replaced by replace(symbol). Numbers, character literals, the
+parameters \param and the local labels %%label of macros are left as they are. */
+func replaceSymbols(code string, replace func(string) string) string {
+	var builder strings.Builder
+	for i := 0; i < len(code); i++ {
+		if code[i] == '\'' { // Case: a character literal such as 'A' or '\''
+			var end int = i + 1
+			for end < len(code) && code[end] != '\'' {
+				if code[end] == '\\' {
+					end = end + 1
+				}
+				end = end + 1
+			}
+			if end >= len(code) {
+				end = len(code) - 1
+			}
+			builder.WriteString(code[i : end+1])
+			i = end
+		} else if code[i] == '\\' || strings.HasPrefix(code[i:], "%%") {
+			var start int = i + 1
+			if code[i] == '%' {
+				start = i + 2
+			}
+			var end int = start + len(symbolPrefix(code[start:]))
+			builder.WriteString(code[i:end])
+			i = end - 1
+		} else if isSymbol("_" + code[i:i+1]) {
+			var end int = i + len(symbolPrefix(code[i:]))
+			var token string = code[i:end]
+			if isSymbol(token) {
+				token = replace(token)
+			}
+			builder.WriteString(token)
+			i = end - 1
+		} else {
+			builder.WriteByte(code[i])
+		}
+	}
+	return builder.String()
+}
+
+// Question: "Is the line blank?" The empty lines of a block comment are not.
+func (line formattedLine) blank() bool {
+	return line.code == "" && line.comment == "" && line.verbatim == false
+}
+
+/* Lays out the lines: the trailing comments of a block of lines without a blank line in between start
+in the same column, one space after the longest command among them. */
+func writeFormatted(lines []formattedLine) []byte {
+	var kept []formattedLine
+	for _, line := range lines {
+		if line.blank() && (len(kept) == 0 || kept[len(kept)-1].blank()) {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	for len(kept) > 0 && kept[len(kept)-1].blank() {
+		kept = kept[:len(kept)-1]
+	}
+
+	var prefixes []string = make([]string, len(kept))
+	var indented bool = false // whether the next command is indented, for the comments above it
+	for index := len(kept) - 1; index >= 0; index-- {
+		if kept[index].code != "" {
+			indented = kept[index].indented
+			prefixes[index] = kept[index].code
+		} else if kept[index].blank() || kept[index].verbatim {
+			indented = false // a comment that a blank line or a block comment separates from the code starts in the first column
+		}
+		if indented && kept[index].blank() == false && kept[index].verbatim == false {
+			prefixes[index] = formatIndent + prefixes[index]
+		}
+	}
+
+	var buffer bytes.Buffer
+	for start := 0; start < len(kept); {
+		var end int = start + 1
+		for end < len(kept) && kept[end].blank() == false && kept[start].blank() == false {
+			end = end + 1
+		}
+		var column int = 0 // the column of the trailing comments of the block of lines from start to end
+		for index := start; index < end; index++ {
+			if kept[index].code != "" && kept[index].comment != "" && len(prefixes[index])+1 > column {
+				column = len(prefixes[index]) + 1
+			}
+		}
+		for index := start; index < end; index++ {
+			var text string = prefixes[index]
+			if kept[index].code != "" && kept[index].comment != "" {
+				for len(text) < column {
+					text = text + " "
+				}
+			}
+			buffer.WriteString(text + kept[index].comment + "\n")
+		}
+		start = end
+	}
+	return buffer.Bytes()
+}
@@ -0,0 +1,61 @@
+package hackasm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	var tests = []struct {
+		source   string
+		expected string
+	}{
+		{"(LOOP)   // top\n  @i\nD = M ;JGT // x\n\n\n   @LOOP\n0;JMP\n", "(LOOP)      // top\n    @i\n    D=M;JGT // x\n\n    @LOOP\n    0;JMP\n"},
+		{"  .equ ROWS 32\n@ROWS\n", ".equ ROWS 32\n    @ROWS\n"},
+		{"@x /* kept */\n  D=M\n", "@x /* kept */\n    D=M\n"},
+	}
+	for _, test := range tests {
+		formatted, err := Format(strings.NewReader(test.source), Options{FileName: "F.asm"}, false)
+		if err != nil {
+			t.Errorf("%q: %v", test.source, err)
+		} else if string(formatted) != test.expected {
+			t.Errorf("%q:\ngot      %q\nexpected %q", test.source, formatted, test.expected)
+		}
+	}
+}
+
+// Formatting keeps the machine code of every program, and a formatted program formats into the same bytes
+func TestFormatKeepsMachineCode(t *testing.T) {
+	for _, name := range asmFiles {
+		t.Run(name, func(t *testing.T) {
+			formatted, err1 := Format(bytes.NewReader(readAsmFile(t, name, ".asm")), Options{FileName: name + ".asm"}, false)
+			if err1 != nil {
+				t.Fatal(err1)
+			}
+			words, _ := mustAssemble(t, string(formatted), Options{FileName: name + ".asm"})
+			if hackText(t, words) != strings.Replace(string(readAsmFile(t, name, ".hack")), "\r\n", "\n", -1) {
+				t.Errorf("the formatted %s.asm does not assemble into %s.hack", name, name)
+			}
+			again, err2 := Format(bytes.NewReader(formatted), Options{FileName: name + ".asm"}, false)
+			if err2 != nil {
+				t.Fatal(err2)
+			}
+			if bytes.Equal(again, formatted) == false {
+				t.Errorf("formatting %s.asm twice changes it again", name)
+			}
+		})
+	}
+}
+
+func TestFormatRenumbersLabels(t *testing.T) {
+	var source string = "(L_0042)\n@L_0007\n0;JMP\n(L_0007)\n@L_0042\n0;JMP\n"
+	formatted, err := Format(strings.NewReader(source), Options{FileName: "F.asm"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected string = "(L_0000)\n    @L_0001\n    0;JMP\n(L_0001)\n    @L_0000\n    0;JMP\n"
+	if string(formatted) != expected {
+		t.Errorf("got %q, expected %q", formatted, expected)
+	}
+}
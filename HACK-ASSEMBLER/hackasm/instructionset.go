@@ -0,0 +1,175 @@
+package hackasm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/* General description: "Holds the mnemonics of a Hack CPU and their binary codes, which the Parser
+validates C-instructions against." A C-instruction is encoded as 1, then the 9 bits of its comp,
+then the 3 bits of its dest and the 3 bits of its jump. The comp codes of the standard Hack CPU
+are the 7 bits of code_comp after the bits 11; CPUs that extend the instruction set use the
+other values of these two bits, such as 01 for the shifts of the extended table. */
+type InstructionSet struct {
+	Name string            `json:"name"`
+	Dest map[string]string `json:"dest"` // 3-bit codes
+	Comp map[string]string `json:"comp"` // 9-bit codes, or 7-bit codes that the bits 11 precede
+	Jump map[string]string `json:"jump"` // 3-bit codes
+}
+
+// Returns the instruction set of the standard Hack CPU, as given by code_dest, code_comp and code_jump
+func standardInstructionSet() *InstructionSet {
+	var instructionset *InstructionSet = &InstructionSet{Name: "hack", Dest: map[string]string{}, Comp: map[string]string{}, Jump: map[string]string{}}
+	for mnemonic, code := range code_dest {
+		instructionset.Dest[mnemonic] = code
+	}
+	for mnemonic, code := range code_comp {
+		instructionset.Comp[mnemonic] = "11" + code
+	}
+	for mnemonic, code := range code_jump {
+		instructionset.Jump[mnemonic] = code
+	}
+	return instructionset
+}
+
+// Returns the standard instruction set along with shifts by one bit, encoded with the bits 01 in front of a and c1..c6
+func extendedInstructionSet() *InstructionSet {
+	var instructionset *InstructionSet = standardInstructionSet()
+	instructionset.Name = "extended"
+	instructionset.Comp["A<<"] = "010100000"
+	instructionset.Comp["D<<"] = "010110000"
+	instructionset.Comp["M<<"] = "011100000"
+	instructionset.Comp["A>>"] = "010000000"
+	instructionset.Comp["D>>"] = "010010000"
+	instructionset.Comp["M>>"] = "011000000"
+	return instructionset
+}
+
+// The instruction sets that can be selected by name
+var instruction_sets = map[string]func() *InstructionSet{
+	"hack":     standardInstructionSet,
+	"extended": extendedInstructionSet,
+}
+
+// Returns the names of the built-in instruction sets, in alphabetical order
+func InstructionSetNames() []string {
+	var names []string
+	for name := range instruction_sets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns the built-in instruction set of the given name, and whether there is one
+func BuiltinInstructionSet(name string) (*InstructionSet, bool) {
+	newInstructionSet, ok := instruction_sets[name]
+	if ok == false {
+		return nil, false
+	}
+	return newInstructionSet(), true
+}
+
+/* Reads an instruction set from JSON such as
+{"name": "mycpu", "dest": {"": "000", "M": "001", ...}, "comp": {"0": "0101010", "D<<": "010110000", ...}, "jump": {"": "000", ...}}
+and checks that every code has the right number of binary digits. */
+func LoadInstructionSet(reader io.Reader, fileName string) (*InstructionSet, error) {
+	var instructionset InstructionSet
+	if err1 := json.NewDecoder(reader).Decode(&instructionset); err1 != nil {
+		return nil, fmt.Errorf("%s: not an instruction set: %v", fileName, err1)
+	}
+	var tables = []struct {
+		name   string
+		table  map[string]string
+		digits string // the valid numbers of digits of a code
+	}{
+		{"dest", instructionset.Dest, "3"},
+		{"comp", instructionset.Comp, "7 or 9"},
+		{"jump", instructionset.Jump, "3"},
+	}
+	for _, table := range tables {
+		if len(table.table) == 0 {
+			return nil, fmt.Errorf("%s: the %s table is missing", fileName, table.name)
+		}
+		for mnemonic, code := range table.table {
+			if strings.ContainsAny(mnemonic, "=; \t/") {
+				return nil, fmt.Errorf("%s: invalid %s mnemonic '%s'", fileName, table.name, mnemonic)
+			} else if strings.Trim(code, "01") != "" || strings.Contains(table.digits, strconv.Itoa(len(code))) == false {
+				return nil, fmt.Errorf("%s: the %s code of '%s' must be %s binary digits", fileName, table.name, mnemonic, table.digits)
+			} else if len(code) == 7 {
+				table.table[mnemonic] = "11" + code
+			}
+		}
+	}
+	if _, ok := instructionset.Dest[""]; ok == false {
+		return nil, fmt.Errorf("%s: the dest table has no code for an empty dest", fileName)
+	} else if _, ok := instructionset.Jump[""]; ok == false {
+		return nil, fmt.Errorf("%s: the jump table has no code for an empty jump", fileName)
+	}
+	if instructionset.Name == "" {
+		instructionset.Name = fileName
+	}
+	return &instructionset, nil
+}
+
+// Returns the binary code of the dest mnemonic, and whether the mnemonic is valid. With aliases set, DM stands for MD.
+func (instructionset *InstructionSet) dest(mnemonic string, aliases bool) (string, bool) {
+	code, ok := instructionset.Dest[mnemonic]
+	if aliases && ok == false {
+		code, ok = instructionset.Dest[normalizeDest(mnemonic)]
+	}
+	return code, ok
+}
+
+// Returns the binary code of the comp mnemonic, and whether the mnemonic is valid. With aliases set, M+D stands for D+M.
+func (instructionset *InstructionSet) comp(mnemonic string, aliases bool) (string, bool) {
+	code, ok := instructionset.Comp[mnemonic]
+	if aliases && ok == false && len(mnemonic) == 3 && strings.ContainsRune("+&|", rune(mnemonic[1])) {
+		code, ok = instructionset.Comp[mnemonic[2:]+mnemonic[1:2]+mnemonic[:1]] // the operands of + & | commute
+	}
+	return code, ok
+}
+
+// Returns the binary code of the jump mnemonic, and whether the mnemonic is valid
+func (instructionset *InstructionSet) jump(mnemonic string) (string, bool) {
+	code, ok := instructionset.Jump[mnemonic]
+	return code, ok
+}
+
+// Returns the registers of dest in the order A, M, D, e.g. "MD" for "DM", or dest itself if it repeats or names other registers
+func normalizeDest(destMnemonic string) string {
+	var normalized string
+	for _, register := range "AMD" {
+		if strings.Count(destMnemonic, string(register)) > 1 {
+			return destMnemonic
+		} else if strings.ContainsRune(destMnemonic, register) {
+			normalized = normalized + string(register)
+		}
+	}
+	if len(normalized) != len(destMnemonic) {
+		return destMnemonic
+	}
+	return normalized
+}
+
+/* Returns the dest and comp mnemonics in the spelling of the instruction set: a dest whose registers
+are out of order and a comp whose operands are swapped are rewritten when only the other spelling
+is in the tables, e.g. "MD" and "D+M" for "DM" and "M+D". */
+func (instructionset *InstructionSet) canonical(destMnemonic string, compMnemonic string) (string, string) {
+	if _, ok := instructionset.Dest[destMnemonic]; ok == false {
+		if _, ok := instructionset.Dest[normalizeDest(destMnemonic)]; ok {
+			destMnemonic = normalizeDest(destMnemonic)
+		}
+	}
+	if _, ok := instructionset.Comp[compMnemonic]; ok == false && len(compMnemonic) == 3 && strings.ContainsRune("+&|", rune(compMnemonic[1])) {
+		var swapped string = compMnemonic[2:] + compMnemonic[1:2] + compMnemonic[:1]
+		if _, ok := instructionset.Comp[swapped]; ok {
+			compMnemonic = swapped
+		}
+	}
+	return destMnemonic, compMnemonic
+}
@@ -0,0 +1,99 @@
+package hackasm
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuiltinInstructionSets(t *testing.T) {
+	if names := InstructionSetNames(); reflect.DeepEqual(names, []string{"extended", "hack"}) == false {
+		t.Errorf("InstructionSetNames() = %q", names)
+	}
+	if _, ok := BuiltinInstructionSet("nosuch"); ok {
+		t.Errorf("BuiltinInstructionSet(\"nosuch\") found an instruction set")
+	}
+	extended, _ := BuiltinInstructionSet("extended")
+	words, _ := mustAssemble(t, "D=D<<\nAM=M>>;JGT\n", Options{FileName: "Shift.asm", InstructionSet: extended})
+	if expected := "1010110000010000\n1011000000101001\n"; hackText(t, words) != expected {
+		t.Errorf("got\n%sexpected\n%s", hackText(t, words), expected)
+	}
+	if _, _, err := Assemble(strings.NewReader("D=D<<\n"), Options{FileName: "Shift.asm"}); err == nil {
+		t.Errorf("the standard instruction set accepts D=D<<")
+	}
+}
+
+// An instruction set written as JSON loads back into the same tables and assembles the same words
+func TestLoadInstructionSetRoundTrip(t *testing.T) {
+	extended, _ := BuiltinInstructionSet("extended")
+	encoded, err1 := json.Marshal(extended)
+	if err1 != nil {
+		t.Fatal(err1)
+	}
+	loaded, err2 := LoadInstructionSet(bytes.NewReader(encoded), "extended.json")
+	if err2 != nil {
+		t.Fatal(err2)
+	}
+	if reflect.DeepEqual(loaded, extended) == false {
+		t.Errorf("the loaded instruction set differs from the one written")
+	}
+	// Case: 7-bit comp codes are preceded by the bits 11, and a missing name is the file name
+	var source string = `{"dest": {"": "000", "D": "010"}, "comp": {"1": "0111111", "D<<": "010110000"}, "jump": {"": "000"}}`
+	small, err3 := LoadInstructionSet(strings.NewReader(source), "small.json")
+	if err3 != nil {
+		t.Fatal(err3)
+	}
+	if small.Name != "small.json" || small.Comp["1"] != "110111111" || small.Comp["D<<"] != "010110000" {
+		t.Errorf("got %+v", small)
+	}
+}
+
+func TestLoadInstructionSetErrors(t *testing.T) {
+	var tests = []struct {
+		source   string
+		expected string
+	}{
+		{`[]`, "I.json: not an instruction set: "},
+		{`{"comp": {"0": "0101010"}, "jump": {"": "000"}}`, "I.json: the dest table is missing"},
+		{`{"dest": {"": "000"}, "comp": {"0": "0101010"}}`, "I.json: the jump table is missing"},
+		{`{"dest": {"": "000", "M=": "001"}, "comp": {"0": "0101010"}, "jump": {"": "000"}}`, "I.json: invalid dest mnemonic 'M='"},
+		{`{"dest": {"": "000"}, "comp": {"0": "01010"}, "jump": {"": "000"}}`, "I.json: the comp code of '0' must be 7 or 9 binary digits"},
+		{`{"dest": {"": "000"}, "comp": {"0": "0101010"}, "jump": {"": "002"}}`, "I.json: the jump code of '' must be 3 binary digits"},
+		{`{"dest": {"M": "001"}, "comp": {"0": "0101010"}, "jump": {"": "000"}}`, "I.json: the dest table has no code for an empty dest"},
+		{`{"dest": {"": "000"}, "comp": {"0": "0101010"}, "jump": {"JMP": "111"}}`, "I.json: the jump table has no code for an empty jump"},
+	}
+	for _, test := range tests {
+		_, err := LoadInstructionSet(strings.NewReader(test.source), "I.json")
+		if err == nil || strings.HasPrefix(err.Error(), test.expected) == false {
+			t.Errorf("%s: got error %v, expected %q", test.source, err, test.expected)
+		}
+	}
+}
+
+// With Options.Aliases, a dest out of order and a comp with swapped operands assemble like their usual spelling
+func TestAliases(t *testing.T) {
+	var tests = []struct {
+		alias    string
+		expected string
+	}{
+		{"DM=M+D", "MD=D+M"},
+		{"DAM=A&D", "AMD=D&A"},
+		{"D=M|D;JEQ", "D=D|M;JEQ"},
+		{"M=1+D", "M=D+1"},
+	}
+	for _, test := range tests {
+		words, _ := mustAssemble(t, test.alias+"\n", Options{FileName: "Alias.asm", Aliases: true})
+		expected, _ := mustAssemble(t, test.expected+"\n", Options{FileName: "Expected.asm"})
+		if hackText(t, words) != hackText(t, expected) {
+			t.Errorf("%s assembles like %s, expected %s", test.alias, Disassemble(words, false), test.expected)
+		}
+		if _, _, err := Assemble(strings.NewReader(test.alias+"\n"), Options{FileName: "Alias.asm"}); err == nil {
+			t.Errorf("%s is accepted without Options.Aliases", test.alias)
+		}
+	}
+	if normalized := normalizeDest("DMM"); normalized != "DMM" {
+		t.Errorf("normalizeDest(\"DMM\") = %s, expected it unchanged", normalized)
+	}
+}
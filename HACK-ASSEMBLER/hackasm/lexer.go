@@ -0,0 +1,108 @@
+package hackasm
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// General description: "Cleans up every line of the input before the Preprocessor looks at it."
+// A byte order mark at the start of a file and the carriage return of a Windows line ending are
+// dropped, block comments are replaced by spaces, so that the columns of the rest of the line stay
+// the same, and characters that cannot appear in any command are reported. A block comment starts
+// with /* and ends at the first */, possibly on a later line. Neither starts inside a // comment or
+// inside a character literal.
+
+const byteOrderMark = "\uFEFF"
+
+// The characters that commands may hold besides letters, digits, spaces and tabs
+const commandCharacters = "_.$:@()=;+-!&|<>*/%^~,\\'\""
+
+// Whether each ASCII character is one of commandCharacters, which strayCharacter() looks up for every character of the input
+var commandCharacterSet [128]bool = characterSet(commandCharacters)
+
+// Returns the set of the ASCII characters of chars
+func characterSet(chars string) [128]bool {
+	var set [128]bool
+	for i := 0; i < len(chars); i++ {
+		set[chars[i]] = true
+	}
+	return set
+}
+
+/* Returns text with its block comments replaced by spaces, given whether a block comment is open at
+its start, along with whether one is still open at its end and the offset at which the last block
+comment that is still open starts, -1 if it starts on an earlier line. */
+func stripBlockComments(text string, inComment bool) (string, bool, int) {
+	if inComment == false && strings.Contains(text, "/*") == false {
+		return text, false, -1 // most lines hold no block comment
+	}
+	var cleaned []byte = []byte(text)
+	var opened int = -1
+	for i := 0; i < len(cleaned); i++ {
+		if inComment {
+			if strings.HasPrefix(text[i:], "*/") {
+				cleaned[i], cleaned[i+1] = ' ', ' '
+				inComment = false
+				i = i + 1
+			} else {
+				cleaned[i] = ' '
+			}
+		} else if strings.HasPrefix(text[i:], "//") {
+			break // the rest of the line is a line comment
+		} else if strings.HasPrefix(text[i:], "/*") {
+			cleaned[i], cleaned[i+1] = ' ', ' '
+			inComment = true
+			opened = i
+			i = i + 1
+		} else if text[i] == '\'' || text[i] == '"' { // Case: a character literal or the path of .include
+			i = i + literalLength(text[i:]) - 1
+		}
+	}
+	return string(cleaned), inComment, opened
+}
+
+// Returns the length of the literal that starts text, a quote up to the matching quote or the end of text
+func literalLength(text string) int {
+	for end := 1; end < len(text); end++ {
+		if text[end] == '\\' && text[0] == '\'' {
+			end = end + 1
+		} else if text[end] == text[0] {
+			return end + 1
+		}
+	}
+	return len(text)
+}
+
+// Returns the number of spaces and tabs at the start of text
+func leadingSpaces(text string) int {
+	var i int = 0
+	for i < len(text) && (text[i] == ' ' || text[i] == '\t') {
+		i = i + 1
+	}
+	return i
+}
+
+/* Returns the offset in code of the first character that cannot appear in a command, along with a
+description of it, or -1 if there is none. Character literals such as '#' may hold any character. */
+func strayCharacter(code string) (int, string) {
+	for i := 0; i < len(code); i++ {
+		var char byte = code[i]
+		var isLetter bool = (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z')
+		var isDigit bool = char >= '0' && char <= '9'
+		if char == '\'' {
+			i = i + literalLength(code[i:]) - 1
+		} else if char >= 0x80 {
+			var runes []rune = []rune(code[i:])
+			if unicode.IsPrint(runes[0]) == false { // Case: such as a no-break space
+				return i, fmt.Sprintf("U+%04X", runes[0])
+			}
+			return i, fmt.Sprintf("U+%04X '%c'", runes[0], runes[0])
+		} else if char < ' ' && char != '\t' {
+			return i, fmt.Sprintf("U+%04X", char)
+		} else if isLetter == false && isDigit == false && char != ' ' && char != '\t' && commandCharacterSet[char] == false {
+			return i, fmt.Sprintf("'%c'", char)
+		}
+	}
+	return -1, ""
+}
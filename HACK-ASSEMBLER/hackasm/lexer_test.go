@@ -0,0 +1,75 @@
+package hackasm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStripBlockComments(t *testing.T) {
+	var tests = []struct {
+		text      string
+		inComment bool
+		expected  string
+		stillOpen bool
+		opened    int
+	}{
+		{"D=M // no block comment", false, "D=M // no block comment", false, -1},
+		{"@1 /* one */ D=A", false, "@1           D=A", false, 3},
+		{"@1 /* open", false, "@1        ", true, 3},
+		{"still */ D=A", true, "         D=A", false, -1},
+		{"all of it", true, "         ", true, -1},
+		{"D=A // not /* a block comment", false, "D=A // not /* a block comment", false, -1},
+		{"@'/*' /* x */", false, "@'/*'        ", false, 6},
+		{".include \"/*.asm\"", false, ".include \"/*.asm\"", false, -1},
+		{"*/ /* again", true, "           ", true, 3},
+	}
+	for _, test := range tests {
+		text, stillOpen, opened := stripBlockComments(test.text, test.inComment)
+		if text != test.expected || stillOpen != test.stillOpen || opened != test.opened {
+			t.Errorf("stripBlockComments(%q, %v) = %q, %v, %d, expected %q, %v, %d", test.text, test.inComment, text, stillOpen, opened, test.expected, test.stillOpen, test.opened)
+		}
+	}
+}
+
+// Byte order marks, Windows line endings, block comments and spaces within C-instructions leave the words as they are
+func TestLexer(t *testing.T) {
+	var expected string = "@2\nD=A\n@3\nD=D+A\n@0\nM=D;JGT\n"
+	var tests = []string{
+		"\uFEFF@2\nD=A\n@3\nD=D+A\n@0\nM=D;JGT\n",
+		"@2\r\nD=A\r\n@3\r\nD=D+A\r\n@0\r\nM=D;JGT\r\n",
+		"/* Adds 2 and 3 */ @2\nD=A /* first\n   the second */ @3\nD=D+A\n@0\n/**/M=D;JGT\n",
+		"@2\nD = A\n@3\n D\t=  D + A\n@0\nM = D ; JGT // stores the sum\n",
+	}
+	expectedWords, _ := mustAssemble(t, expected, Options{FileName: "Add.asm"})
+	for _, source := range tests {
+		words, _ := mustAssemble(t, source, Options{FileName: "Add.asm"})
+		if got := hackText(t, words); got != hackText(t, expectedWords) {
+			t.Errorf("%q:\ngot\n%sexpected\n%s", source, got, hackText(t, expectedWords))
+		}
+	}
+}
+
+func TestLexerErrors(t *testing.T) {
+	var tests = []struct {
+		source   string
+		expected string
+	}{
+		{"@1\nD=A /* never closed\n@2\n", "L.asm:2:5: unterminated block comment"},
+		{"@1 # one\n", "L.asm:1:4: stray character '#'"},
+		{"@1\nD=A\u00A0\n", "L.asm:2:4: stray character U+00A0"},
+		{"@1\u00E9\n", "L.asm:1:3: stray character U+00E9 'é'"},
+		{"@1\x01\n", "L.asm:1:3: stray character U+0001"},
+		{"@1\n\uFEFF@2\n", "L.asm:2:1: stray character U+FEFF"},
+		{"D = M +\n", "L.asm:1:5: unknown comp 'M+'"},
+	}
+	for _, test := range tests {
+		_, _, err := Assemble(strings.NewReader(test.source), Options{FileName: "L.asm"})
+		if err == nil || strings.Split(err.Error(), "\n")[0] != test.expected {
+			t.Errorf("%q: got error %v, expected %q", test.source, err, test.expected)
+		}
+	}
+	// Case: a stray character is ignored within a comment or a character literal
+	for _, source := range []string{"@1 // café #1\n", "@'#'\n", "/* # */ @1\n"} {
+		mustAssemble(t, source, Options{FileName: "L.asm"})
+	}
+}
@@ -0,0 +1,81 @@
+package hackasm
+
+import (
+	"fmt"
+)
+
+// General description: "Combines object files into one program and resolves the symbols they share."
+
+// An exported label, along with the object that exports it
+type linkedLabel struct {
+	address int // ROM address in the linked program
+	object  *Object
+	line    int
+}
+
+/* Links the objects into one program and returns its words along with a symbol table of the
+exported labels and the variables. The code of each object follows the code of the previous one,
+so the first object starts at ROM address 0 and holds the entry point. Variables are allocated
+from RAM address 16 onwards, in order of first use, and a variable of the same name in several
+objects is one variable. Duplicate exports, unresolved imports and variables that clash with an
+exported label are returned together as an ErrorList. */
+func Link(objects []*Object) ([]uint16, *SymbolTable, error) {
+	var errors ErrorList
+	var bases []int
+	var size int = 0
+	for _, object := range objects {
+		bases = append(bases, size)
+		size = size + len(object.Code)
+	}
+	if size > 32768 {
+		return nil, nil, fmt.Errorf("the linked program of %d words does not fit in the 32K ROM", size)
+	}
+
+	var symboltable *SymbolTable = InitSymbolTable()
+	var labels map[string]linkedLabel = map[string]linkedLabel{}
+	for index, object := range objects {
+		for _, export := range object.Exports {
+			if previous, ok := labels[export.Name]; ok {
+				errors = append(errors, &SourceError{File: object.File, Line: export.Line, Message: fmt.Sprintf("duplicate label '%s' (also exported by %s:%d)", export.Name, previous.object.File, previous.line)})
+				continue
+			}
+			labels[export.Name] = linkedLabel{address: bases[index] + export.Address, object: object, line: export.Line}
+			symboltable.AddEntry(export.Name, bases[index]+export.Address, LABEL_SYMBOL, export.Line)
+		}
+	}
+
+	var ramAddress int = 16
+	for _, object := range objects {
+		for _, variable := range object.Variables {
+			if label, ok := labels[variable.Name]; ok {
+				errors = append(errors, &SourceError{File: object.File, Line: variable.Line, Message: fmt.Sprintf("'%s' is a variable here but a label exported by %s:%d; declare it with .import", variable.Name, label.object.File, label.line)})
+			} else if symboltable.Contains(variable.Name) == false {
+				symboltable.AddEntry(variable.Name, ramAddress, VARIABLE_SYMBOL, variable.Line)
+				ramAddress = ramAddress + 1
+			}
+		}
+		for _, symbol := range object.Imports {
+			if _, ok := labels[symbol.Name]; ok == false {
+				errors = append(errors, &SourceError{File: object.File, Line: symbol.Line, Message: fmt.Sprintf("unresolved symbol '%s': no object exports it", symbol.Name)})
+			}
+		}
+	}
+	if len(errors) > 0 {
+		return nil, nil, errors
+	}
+
+	var words []uint16 = make([]uint16, 0, size)
+	for index, object := range objects {
+		var code []uint16 = append([]uint16{}, object.Code...)
+		for _, relocation := range object.Relocations {
+			switch relocation.Kind {
+			case "rom":
+				code[relocation.Address] = code[relocation.Address] + uint16(bases[index])
+			case "import", "variable":
+				code[relocation.Address] = uint16(symboltable.GetAddress(relocation.Symbol))
+			}
+		}
+		words = append(words, code...)
+	}
+	return words, symboltable, nil
+}
@@ -0,0 +1,89 @@
+package hackasm
+
+import (
+	"strings"
+	"testing"
+)
+
+// Assembles each source into an object file and fails the test if one is invalid
+func mustAssembleObjects(t *testing.T, sources map[string]string, order []string) []*Object {
+	t.Helper()
+	var objects []*Object
+	for _, name := range order {
+		object, _, err := AssembleObject(strings.NewReader(sources[name]), Options{FileName: name})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		objects = append(objects, object)
+	}
+	return objects
+}
+
+// Linking the files of a program gives the words of the program assembled as one file
+func TestLink(t *testing.T) {
+	var sources map[string]string = map[string]string{
+		"Main.asm": ".import Double\n@3\nD=A\n@x\nM=D\n@RETURN\nD=A\n@R15\nM=D\n@Double\n0;JMP\n(RETURN)\n@RETURN\n0;JMP\n",
+		"Math.asm": ".export Double\n(Double)\n@x\nD=M\nM=D+M\n@count\nM=M+1\n(LOOP)\n@LOOP\nD;JLT\n@R15\nA=M\n0;JMP\n",
+	}
+	words, symboltable, err := Link(mustAssembleObjects(t, sources, []string{"Main.asm", "Math.asm"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var whole string = strings.Replace(sources["Main.asm"], ".import Double\n", "", 1) + strings.Replace(sources["Math.asm"], ".export Double\n", "", 1)
+	expected, _ := mustAssemble(t, whole, Options{FileName: "Program.asm"})
+	if hackText(t, words) != hackText(t, expected) {
+		t.Errorf("the linked words differ from the words of the program assembled as one file")
+	}
+	var tests = []struct {
+		symbol  string
+		address int
+		kind    int
+		line    int
+	}{
+		{"Double", 12, LABEL_SYMBOL, 2}, // the line of (Double), not of .export Double
+		{"x", 16, VARIABLE_SYMBOL, 4},
+		{"count", 17, VARIABLE_SYMBOL, 6},
+	}
+	for _, test := range tests {
+		if symboltable.GetAddress(test.symbol) != test.address || symboltable.Kind(test.symbol) != test.kind || symboltable.Line(test.symbol) != test.line {
+			t.Errorf("%s: address %d kind %d line %d, expected address %d kind %d line %d", test.symbol, symboltable.GetAddress(test.symbol), symboltable.Kind(test.symbol), symboltable.Line(test.symbol), test.address, test.kind, test.line)
+		}
+	}
+}
+
+func TestLinkErrors(t *testing.T) {
+	var tests = []struct {
+		sources  map[string]string
+		expected string
+	}{
+		{map[string]string{"A.asm": ".export F\n(F)\n@F\n0;JMP\n", "B.asm": ".export F\n(F)\n@F\n0;JMP\n"}, "B.asm:2: duplicate label 'F' (also exported by A.asm:2)"},
+		{map[string]string{"A.asm": ".import G\n@G\n0;JMP\n", "B.asm": "@0\n"}, "A.asm:1: unresolved symbol 'G': no object exports it"},
+		{map[string]string{"A.asm": "@F\nM=1\n", "B.asm": ".export F\n(F)\n@F\n0;JMP\n"}, "A.asm:1: 'F' is a variable here but a label exported by B.asm:2; declare it with .import"},
+	}
+	for _, test := range tests {
+		_, _, err := Link(mustAssembleObjects(t, test.sources, []string{"A.asm", "B.asm"}))
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("got error %v, expected %s", err, test.expected)
+		}
+	}
+}
+
+// Object files keep their contents through WriteObject() and ReadObject()
+func TestObjectRoundTrip(t *testing.T) {
+	var objects []*Object = mustAssembleObjects(t, map[string]string{"Max.asm": string(readAsmFile(t, "Max", ".asm"))}, []string{"Max.asm"})
+	var buffer strings.Builder
+	if err1 := WriteObject(&buffer, objects[0]); err1 != nil {
+		t.Fatal(err1)
+	}
+	object, err2 := ReadObject(strings.NewReader(buffer.String()), "Max.obj")
+	if err2 != nil {
+		t.Fatal(err2)
+	}
+	words, _, err3 := Link([]*Object{object})
+	if err3 != nil {
+		t.Fatal(err3)
+	}
+	if hackText(t, words) != strings.Replace(string(readAsmFile(t, "Max", ".hack")), "\r\n", "\n", -1) {
+		t.Errorf("the linked Max.obj does not match Max.hack")
+	}
+}
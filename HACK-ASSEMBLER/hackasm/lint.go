@@ -0,0 +1,315 @@
+package hackasm
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+/* General description: "Flags the likely bugs of a valid program." Every warning names the rule
+that found it, and a rule is suppressed for one line by a comment such as
+
+	@counter // lint:ignore variable-typo
+
+or for the whole program by passing its ID to Lint(). */
+
+// The rules of the linter, by ID
+var lint_rules = map[string]string{
+	"variable-typo":       "a variable whose name differs from a label only by case or by one character",
+	"unused-label":        "a label that no instruction refers to",
+	"unreachable-code":    "instructions after an unconditional jump that no label or numeric jump target leads to",
+	"jump-writes-a":       "a C-instruction that jumps and also writes to A, whose new value is not the jump target",
+	"shadowed-predefined": "a label that redefines a predefined symbol such as SP or R13",
+}
+
+// A likely bug found by Lint(), reported as "file.asm:line:column: warning: message [rule]"
+type Warning struct {
+	File    string
+	Line    int
+	Column  int
+	Rule    string
+	Message string
+}
+
+func (warning *Warning) String() string {
+	return fmt.Sprintf("%s:%d:%d: warning: %s [%s]", warning.File, warning.Line, warning.Column, warning.Message, warning.Rule)
+}
+
+// Returns the IDs of the lint rules, in alphabetical order, along with their descriptions
+func LintRules() ([]string, map[string]string) {
+	var rules []string
+	var descriptions map[string]string = map[string]string{}
+	for rule, description := range lint_rules {
+		rules = append(rules, rule)
+		descriptions[rule] = description
+	}
+	sort.Strings(rules)
+	return rules, descriptions
+}
+
+// The state of one run of the linter
+type lintState struct {
+	fileName string
+	disabled map[string]bool
+	warnings []*Warning
+}
+
+// Records a warning of the rule at the given column of source, unless the rule is suppressed there
+func (linter *lintState) warn(rule string, source sourceLine, column int, format string, args ...interface{}) {
+	if linter.disabled[rule] || ignoresRule(source.text, rule) || ignoresRule(source.pseudo, rule) {
+		return
+	}
+	var message string = fmt.Sprintf(format, args...)
+	if source.pseudo != "" {
+		var command string = strings.TrimSpace(withoutComment(source.pseudo))
+		message = fmt.Sprintf("%s (in the expansion of '%s')", message, command)
+		column = strings.Index(source.pseudo, command) + 1
+	}
+	if source.macro != nil {
+		message = fmt.Sprintf("%s (expanded from macro %s at %s:%d:%d)", message, source.macro.name, source.macro.file, source.bodyLine, column)
+		column = source.column
+	}
+	var file string = source.file
+	if file == "" {
+		file = linter.fileName
+	}
+	linter.warnings = append(linter.warnings, &Warning{File: file, Line: source.lineNumber, Column: column, Rule: rule, Message: message})
+}
+
+// Question: "Does the comment of text suppress the rule?" e.g. "// lint:ignore unused-label, jump-writes-a"
+func ignoresRule(text string, rule string) bool {
+	var comment int = strings.Index(text, "//")
+	if comment < 0 {
+		return false
+	}
+	var fields []string = strings.Fields(text[comment+2:])
+	if len(fields) == 0 || fields[0] != "lint:ignore" {
+		return false
+	}
+	for _, field := range fields[1:] {
+		for _, ignored := range strings.Split(field, ",") {
+			if ignored == rule {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+/* Checks the program read from reader for likely bugs and returns the warnings in source order.
+The rules whose IDs are in disabled are not checked. The program is assembled first, and its
+errors are returned instead of warnings if it is invalid. Symbols that the file does not define are
+left to the linker, as with AssembleObject(), so the files of a linked program can be checked one
+at a time. */
+func Lint(reader io.Reader, options Options, disabled []string) ([]*Warning, error) {
+	var linter *lintState = &lintState{disabled: map[string]bool{}}
+	for _, rule := range disabled {
+		if _, ok := lint_rules[rule]; ok == false {
+			return nil, fmt.Errorf("unknown lint rule '%s'", rule)
+		}
+		linter.disabled[rule] = true
+	}
+	var lintOptions Options = options
+	lintOptions.Optimize = false // the warnings refer to the source as written
+	lintOptions.Listing = nil
+	parser, words, symboltable, err1 := assemble(reader, lintOptions, true, 0, nil)
+	if err1 != nil {
+		return nil, err1
+	}
+	linter.fileName = parser.fileName
+
+	var predefined map[string]bool = map[string]bool{}
+	for symbol := range InitSymbolTable().symbols {
+		predefined[symbol] = true
+	}
+	for symbol := range options.Predefined {
+		predefined[symbol] = true
+	}
+	var referenced map[string]bool = map[string]bool{}
+	for _, declaration := range parser.linkage {
+		referenced[declaration.name] = true // an exported label is used by other files
+	}
+	for _, definition := range parser.constants {
+		for _, name := range expressionNames(definition.expression) {
+			referenced[name] = true
+		}
+	}
+	var variables map[string]bool = map[string]bool{}
+	for _, relocation := range parser.relocations {
+		if relocation.Kind == "variable" {
+			variables[relocation.Symbol] = true
+		}
+	}
+	var labels []string
+	for symbol, kind := range symboltable.kinds {
+		if kind == LABEL_SYMBOL {
+			labels = append(labels, symbol)
+		}
+	}
+	sort.Strings(labels)
+
+	var entryPoints map[int]bool = jumpTargets(parser, words, symboltable)
+	parser.rewind() // a fourth pass over the commands, which finds the warnings
+	var romAddress int = 0
+	var labelSources map[string]sourceLine = map[string]sourceLine{}
+	var labelColumns map[string]int = map[string]int{}
+	var reported map[string]bool = map[string]bool{} // variables already reported by variable-typo
+	var unreachable bool = false                     // whether the previous instruction always jumps
+	for parser.HasMoreCommands() {
+		parser.Advance()
+		switch parser.CommandType() {
+		case L_COMMAND:
+			var label string = parser.Symbol()
+			labelSources[label] = parser.currentSource
+			labelColumns[label] = parser.column + 1
+			if predefined[label] {
+				linter.warn("shadowed-predefined", parser.currentSource, parser.column+1, "label '%s' redefines the predefined symbol %s", label, label)
+			}
+			unreachable = false
+		case A_COMMAND:
+			if entryPoints[romAddress] {
+				unreachable = false
+			}
+			romAddress = romAddress + 1
+			if unreachable {
+				linter.warn("unreachable-code", parser.currentSource, parser.column, "'%s' can never run: it follows an unconditional jump and has no label", parser.currentCommand)
+				unreachable = false
+			}
+			var symbol string = parser.Symbol()
+			for _, name := range expressionNames(symbol) {
+				referenced[name] = true
+			}
+			if variables[symbol] && reported[symbol] == false {
+				reported[symbol] = true
+				if label := similarName(symbol, labels); label != "" {
+					linter.warn("variable-typo", parser.currentSource, parser.column+1, "'%s' becomes a variable, but there is a label '%s'", symbol, label)
+				}
+			}
+		case C_COMMAND:
+			if entryPoints[romAddress] {
+				unreachable = false
+			}
+			romAddress = romAddress + 1
+			if unreachable {
+				linter.warn("unreachable-code", parser.currentSource, parser.column, "'%s' can never run: it follows an unconditional jump and has no label", parser.currentCommand)
+			}
+			if parser.Jump() != "" && strings.Contains(parser.Dest(), "A") {
+				linter.warn("jump-writes-a", parser.currentSource, parser.column, "'%s' jumps to the old value of A, not to the value it writes to A", parser.currentCommand)
+			}
+			unreachable = alwaysJumps(parser.Comp(), parser.Jump())
+		}
+	}
+	for _, label := range labels {
+		if source, ok := labelSources[label]; ok && referenced[label] == false {
+			linter.warn("unused-label", source, labelColumns[label], "label '%s' is never used", label)
+		}
+	}
+
+	sort.SliceStable(linter.warnings, func(i, j int) bool {
+		if linter.warnings[i].Line != linter.warnings[j].Line {
+			return linter.warnings[i].Line < linter.warnings[j].Line
+		}
+		return linter.warnings[i].Column < linter.warnings[j].Column
+	})
+	return linter.warnings, nil
+}
+
+/* Returns the ROM addresses that the program jumps to without a label, such as the 133 of @133
+followed by 0;JMP: the values of the numbers, constants, predefined symbols and expressions that
+an A-instruction loads right before a jump. The code at those addresses is reachable. */
+func jumpTargets(parser *Parser, words []uint16, symboltable *SymbolTable) map[int]bool {
+	var targets map[int]bool = map[int]bool{}
+	var romAddress int = 0
+	var loaded string = "" // the symbol of the A-instruction right before the current command, "" if there is none
+	parser.rewind()
+	for parser.HasMoreCommands() {
+		parser.Advance()
+		switch parser.CommandType() {
+		case A_COMMAND:
+			loaded = parser.Symbol()
+			romAddress = romAddress + 1
+			continue
+		case C_COMMAND:
+			var label bool = isSymbol(loaded) && (symboltable.Contains(loaded) == false || symboltable.kinds[loaded] == LABEL_SYMBOL)
+			if loaded != "" && label == false && parser.Jump() != "" {
+				targets[int(words[romAddress-1])] = true
+			}
+			romAddress = romAddress + 1
+		}
+		loaded = ""
+	}
+	return targets
+}
+
+// Question: "Does a C-instruction with the given comp and jump always jump?" e.g. 0;JMP or 0;JEQ
+func alwaysJumps(compMnemonic string, jumpMnemonic string) bool {
+	var jumpsIf map[string][]bool = map[string][]bool{ // whether the jump is taken for a negative, zero and positive comp
+		"JGT": {false, false, true},
+		"JEQ": {false, true, false},
+		"JGE": {false, true, true},
+		"JLT": {true, false, false},
+		"JNE": {true, false, true},
+		"JLE": {true, true, false},
+		"JMP": {true, true, true},
+	}
+	conditions, ok := jumpsIf[jumpMnemonic]
+	if ok == false {
+		return false
+	}
+	switch compMnemonic {
+	case "-1":
+		return conditions[0]
+	case "0":
+		return conditions[1]
+	case "1":
+		return conditions[2]
+	}
+	return conditions[0] && conditions[1] && conditions[2]
+}
+
+/* Returns the label that name most likely misspells: one that differs from it only by case, or,
+for names of three or more characters, by one inserted, deleted or replaced character. */
+func similarName(name string, labels []string) string {
+	for _, label := range labels {
+		if strings.EqualFold(name, label) {
+			return label
+		}
+	}
+	if len(name) < 3 {
+		return ""
+	}
+	for _, label := range labels {
+		if editDistance(strings.ToLower(name), strings.ToLower(label)) == 1 {
+			return label
+		}
+	}
+	return ""
+}
+
+// Returns the number of characters to insert, delete or replace to turn a into b
+func editDistance(a string, b string) int {
+	var previous []int = make([]int, len(b)+1)
+	var current []int = make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			var cost int = 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
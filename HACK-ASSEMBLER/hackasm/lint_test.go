@@ -0,0 +1,56 @@
+package hackasm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// Lints source and returns its warnings, one per line
+func lintWarnings(t *testing.T, source string, disabled []string) string {
+	t.Helper()
+	warnings, err := Lint(strings.NewReader(source), Options{FileName: "L.asm"}, disabled)
+	if err != nil {
+		t.Fatalf("%q: %v", source, err)
+	}
+	var lines []string
+	for _, warning := range warnings {
+		lines = append(lines, warning.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestLint(t *testing.T) {
+	var tests = []struct {
+		source   string
+		disabled []string
+		expected string
+	}{
+		{"(LOOP)\n@Loop\n0;JMP\n", nil, "L.asm:1:2: warning: label 'LOOP' is never used [unused-label]\nL.asm:2:2: warning: 'Loop' becomes a variable, but there is a label 'LOOP' [variable-typo]"},
+		{"(LOOP)\n@Loop\n0;JMP\n", []string{"unused-label", "variable-typo"}, ""},
+		{"(END)\n@END\n0;JMP\n@x\nM=1\n", nil, "L.asm:4:1: warning: '@x' can never run: it follows an unconditional jump and has no label [unreachable-code]"},
+		{"(END)\n@END\n0;JMP\n@x // lint:ignore unreachable-code\nM=1\n", nil, ""},
+		{"@x\nAM=M-1;JGT\n", nil, "L.asm:2:1: warning: 'AM=M-1;JGT' jumps to the old value of A, not to the value it writes to A [jump-writes-a]"},
+		{"(SP)\n@SP\n0;JMP\n", nil, "L.asm:1:2: warning: label 'SP' redefines the predefined symbol SP [shadowed-predefined]"},
+		{"@2\n0;JMP\n@x\nM=1\n@R15\nA=M\n0;JMP\n@x\nM=0\n@7\n0;JMP\n", nil, ""},
+		{".equ ENTRY 4\n@ENTRY\n0;JMP\n@x\n0;JMP\n@x\nM=0\n", nil, "L.asm:4:1: warning: '@x' can never run: it follows an unconditional jump and has no label [unreachable-code]"},
+	}
+	for _, test := range tests {
+		if got := lintWarnings(t, test.source, test.disabled); got != test.expected {
+			t.Errorf("%q:\ngot      %q\nexpected %q", test.source, got, test.expected)
+		}
+	}
+}
+
+// The code of Pong.asm after its unconditional jumps is reached through numeric ROM addresses such as @133
+func TestLintPongHasNoUnreachableCode(t *testing.T) {
+	warnings, err := Lint(bytes.NewReader(readAsmFile(t, "Pong", ".asm")), Options{FileName: "Pong.asm"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, warning := range warnings {
+		if warning.Rule == "unreachable-code" {
+			t.Error(warning)
+		}
+	}
+}
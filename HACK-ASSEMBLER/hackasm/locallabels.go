@@ -0,0 +1,126 @@
+package hackasm
+
+import (
+	"fmt"
+	"strings"
+)
+
+/* General description: "Gives the local labels of a program their full names", so that the code of
+every function can use the same short names without clashing. A label whose name starts with a dot
+is local to the nearest global label before it:
+
+	(Math.multiply)
+	(.loop)          // declares Math.multiply.loop
+	    @.loop       // loads Math.multiply.loop
+	    0;JMP
+
+A numeric label such as (1) may be declared any number of times; @1f loads the address of the next
+(1) and @1b that of the previous one. Its declarations are named like Math.multiply.1$0, counting
+the declarations of the number across the file. The labels declared by the expansion of a macro
+do not start a new scope. The full names are worked out by addLCOMMAND(), and the symbol table and
+every later pass only see those. */
+
+// A reference to a local label by an A-instruction
+type localReference struct {
+	source  sourceLine
+	column  int
+	written string // the name as written, e.g. ".loop" or "1f"
+	scope   string // the global label that the reference is local to, "" before the first
+	command int    // index in commands of the A-instruction
+}
+
+// The local labels seen by the first pass so far
+type localScope struct {
+	global     string                      // the nearest global label declared outside the macros, "" before the first
+	declared   map[string]int              // the number of declarations of each numeric label
+	last       map[string]string           // the full name of the last declaration of each numeric label
+	forward    map[string][]localReference // the references to the next declaration of each numeric label
+	references []localReference            // every reference to a named local label
+}
+
+func initLocalScope() *localScope {
+	return &localScope{declared: map[string]int{}, last: map[string]string{}, forward: map[string][]localReference{}}
+}
+
+// Question: "Is name a numeric label such as 1?"
+func isNumericLabel(name string) bool {
+	return name != "" && strings.Trim(name, "0123456789") == ""
+}
+
+// Question: "Is symbol a reference to a numeric label such as 1f or 1b?"
+func isNumericReference(symbol string) bool {
+	return len(symbol) >= 2 && (strings.HasSuffix(symbol, "f") || strings.HasSuffix(symbol, "b")) && isNumericLabel(symbol[:len(symbol)-1])
+}
+
+// Returns the index in commands of the current command
+func (parser *Parser) commandIndex() int {
+	if parser.replaying {
+		return parser.replayed - 1
+	}
+	return len(parser.commands) - 1
+}
+
+/* Returns the full name of the label declared by the current command, which is label itself for a
+global label, and records it for Symbol(). A global label declared outside the macros starts a new
+scope. */
+func (parser *Parser) declareLabel(scope *localScope, label string) string {
+	var fullName string = label
+	if strings.HasPrefix(label, ".") {
+		fullName = scope.global + label
+	} else if isNumericLabel(label) {
+		fullName = fmt.Sprintf("%s.%s$%d", scope.global, label, scope.declared[label])
+		scope.declared[label] = scope.declared[label] + 1
+		scope.last[label] = fullName
+		for _, reference := range scope.forward[label] {
+			parser.localNames[reference.command] = fullName
+		}
+		delete(scope.forward, label)
+	} else {
+		if parser.currentSource.macro == nil {
+			scope.global = label
+		}
+		return label
+	}
+	parser.localNames[parser.commandIndex()] = fullName
+	return fullName
+}
+
+// Records the full name of the local label that the current A-command refers to, if it refers to one
+func (parser *Parser) referToLabel(scope *localScope, symbol string) {
+	if strings.HasPrefix(symbol, ".") == false && isNumericReference(symbol) == false {
+		return // most symbols are global
+	}
+	var reference localReference = localReference{source: parser.currentSource, column: parser.column + 1, written: symbol, scope: scope.global, command: parser.commandIndex()}
+	if strings.HasPrefix(symbol, ".") && isSymbol(symbol) {
+		parser.localNames[reference.command] = scope.global + symbol
+		scope.references = append(scope.references, reference)
+	} else if isNumericReference(symbol) {
+		var number string = symbol[:len(symbol)-1]
+		parser.localNames[reference.command] = "." + symbol // a placeholder until the label is found
+		if strings.HasSuffix(symbol, "f") {
+			scope.forward[number] = append(scope.forward[number], reference)
+		} else if fullName, ok := scope.last[number]; ok {
+			parser.localNames[reference.command] = fullName
+		} else {
+			parser.errorAt(reference.column, "no label (%s) before '@%s'", number, symbol)
+		}
+	}
+}
+
+// Reports the references to local labels that were never declared, once the first pass is over
+func (parser *Parser) checkLocalLabels(scope *localScope, symboltable *SymbolTable) {
+	for _, reference := range scope.references {
+		if symboltable.kinds[reference.scope+reference.written] == LABEL_SYMBOL {
+			continue
+		} else if reference.scope == "" {
+			parser.errorAtSource(reference.source, reference.column, "no local label (%s) before the first global label", reference.written)
+		} else {
+			parser.errorAtSource(reference.source, reference.column, "no local label (%s) in the scope of (%s)", reference.written, reference.scope)
+		}
+	}
+	for number, references := range scope.forward {
+		for _, reference := range references {
+			parser.errorAtSource(reference.source, reference.column, "no label (%s) after '@%s'", number, reference.written)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package hackasm
+
+import (
+	"strings"
+	"testing"
+)
+
+// A program with local labels assembles into the same words as the program with every label written in full
+func TestLocalLabels(t *testing.T) {
+	var tests = []struct {
+		source   string
+		expected string
+	}{
+		{"(F)\n(.loop)\n@.loop\n0;JMP\n(G)\n(.loop)\n@.loop\n0;JMP\n", "(F)\n(F.loop)\n@F.loop\n0;JMP\n(G)\n(G.loop)\n@G.loop\n0;JMP\n"},
+		{"(F)\n@.end\n0;JMP\n(.end)\n@F.end\n", "(F)\n@F.end\n0;JMP\n(F.end)\n@F.end\n"},
+		{"(1)\n@1b\n@1f\n(1)\n@1b\n@1f\n(1)\n", "(A)\n@A\n@B\n(B)\n@B\n@C\n(C)\n"},
+		{"(F)\n@2f\n(2)\n(G)\n(2)\n@2b\n", "(F)\n@X\n(X)\n(G)\n(Y)\n@Y\n"},
+		{".macro SPIN\n(LOOP)\n@.x\n.endm\n(F)\n  SPIN\n(.x)\n", "(F)\n(LOOP)\n@F.x\n(F.x)\n"},
+		{"@1\nD=A\n", "@1\nD=A\n"},
+	}
+	for _, test := range tests {
+		words, _ := mustAssemble(t, test.source, Options{FileName: "Local.asm"})
+		expected, _ := mustAssemble(t, test.expected, Options{FileName: "Expected.asm"})
+		if hackText(t, words) != hackText(t, expected) {
+			t.Errorf("%q:\ngot      %q\nexpected %q", test.source, Disassemble(words, false), Disassemble(expected, false))
+		}
+	}
+	_, symboltable := mustAssemble(t, "(Math.multiply)\n(.loop)\n(1)\n(1)\n@1b\n", Options{FileName: "Local.asm"})
+	for _, name := range []string{"Math.multiply.loop", "Math.multiply.1$0", "Math.multiply.1$1"} {
+		if symboltable.Contains(name) == false {
+			t.Errorf("the symbol table holds no %s", name)
+		}
+	}
+}
+
+func TestLocalLabelErrors(t *testing.T) {
+	var tests = []struct {
+		source   string
+		expected string
+	}{
+		{"@.loop\n0;JMP\n", "L.asm:1:2: no local label (.loop) before the first global label"},
+		{"(F)\n@.loop\n(G)\n(.loop)\n", "L.asm:2:2: no local label (.loop) in the scope of (F)"},
+		{"@3b\n(3)\n", "L.asm:1:2: no label (3) before '@3b'"},
+		{"(3)\n@3f\n", "L.asm:2:2: no label (3) after '@3f'"},
+	}
+	for _, test := range tests {
+		_, _, err := Assemble(strings.NewReader(test.source), Options{FileName: "L.asm"})
+		if err == nil || strings.Split(err.Error(), "\n")[0] != test.expected {
+			t.Errorf("%q: got error %v, expected %q", test.source, err, test.expected)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package hackasm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+/* General description: "Assembles a file into relocatable machine code that the linker combines
+with the code of other files." Labels stay private to their file unless it declares them with
+
+	.export main, loop
+
+and a file names the labels it uses from other files with
+
+	.import Math.multiply
+
+Every other symbol that a file does not define is a variable, shared by all the linked files. */
+
+const objectFormat = "hack-object/1"
+
+// A label exported or imported by an object file, or a variable that it uses
+type ObjectSymbol struct {
+	Name    string `json:"name"`
+	Address int    `json:"address"` // ROM address of an exported label, relative to the start of the file
+	Line    int    `json:"line"`    // line of the label, of the .import or of the first use of a variable
+}
+
+/* An A-instruction whose word the linker completes. Kind is "rom" for a word that holds a ROM
+address relative to the start of the file, "import" for a label exported by another file and
+"variable" for a variable. */
+type Relocation struct {
+	Address int    `json:"address"` // ROM address of the instruction, relative to the start of the file
+	Kind    string `json:"kind"`
+	Symbol  string `json:"symbol,omitempty"` // the imported label or the variable
+	Line    int    `json:"line"`
+}
+
+// The relocatable machine code of one file
+type Object struct {
+	Format      string         `json:"format"`
+	File        string         `json:"file"`
+	Code        []uint16       `json:"code"`
+	Exports     []ObjectSymbol `json:"exports"`
+	Imports     []ObjectSymbol `json:"imports"`
+	Variables   []ObjectSymbol `json:"variables"`
+	Relocations []Relocation   `json:"relocations"`
+}
+
+// A name declared by .export or .import
+type linkageDeclaration struct {
+	directive string // ".export" or ".import"
+	name      string
+	source    sourceLine
+	column    int
+}
+
+// Records the names declared by the current .export or .import directive
+func (parser *Parser) declareLinkage() {
+	directive, name, rest, _ := parser.directive()
+	var names []string = []string{name}
+	if strings.TrimSpace(rest) != "" {
+		names = append(names, strings.Split(rest, ",")...)
+	}
+	var searchFrom int = len(directive)
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if isSymbol(name) == false {
+			parser.errorAt(parser.column+searchFrom+1, "expected a label name after %s", directive)
+			return
+		}
+		var index int = searchFrom + strings.Index(parser.currentCommand[searchFrom:], name)
+		searchFrom = index + len(name)
+		parser.linkage = append(parser.linkage, linkageDeclaration{directive: directive, name: name, source: parser.currentSource, column: parser.column + index})
+	}
+}
+
+/* Checks the .export and .import declarations against the symbols of the file: only its labels
+can be exported, and only names that it does not define can be imported. */
+func checkLinkage(parser *Parser, symboltable *SymbolTable) {
+	var declared map[string]linkageDeclaration = map[string]linkageDeclaration{}
+	for _, declaration := range parser.linkage {
+		var name string = declaration.name
+		if previous, ok := declared[name]; ok {
+			if previous.directive == declaration.directive {
+				parser.errorAtSource(declaration.source, declaration.column, "'%s' already declared by %s at line %d", name, previous.directive, previous.source.lineNumber)
+			} else {
+				parser.errorAtSource(declaration.source, declaration.column, "'%s' cannot be both exported and imported", name)
+			}
+			continue
+		}
+		declared[name] = declaration
+		if declaration.directive == ".export" && (symboltable.Contains(name) == false || symboltable.kinds[name] != LABEL_SYMBOL) {
+			parser.errorAtSource(declaration.source, declaration.column, "cannot export '%s': it is not a label of this file", name)
+		} else if declaration.directive == ".import" && symboltable.Contains(name) {
+			parser.errorAtSource(declaration.source, declaration.column, "cannot import '%s': it is already defined in this file", name)
+		} else if declaration.directive == ".import" && parser.relocatable == false {
+			parser.errorAtSource(declaration.source, declaration.column, "cannot resolve the imported label '%s' in a single file; assemble with -c and link the object files", name)
+		}
+	}
+}
+
+// Records that the A-instruction at romAddress loads symbol, which is either imported or a variable
+func (parser *Parser) relocateSymbol(romAddress int, symbol string) {
+	var kind string = "variable"
+	for _, declaration := range parser.linkage {
+		if declaration.directive == ".import" && declaration.name == symbol {
+			kind = "import"
+		}
+	}
+	parser.relocations = append(parser.relocations, Relocation{Address: romAddress, Kind: kind, Symbol: symbol, Line: parser.lineNumber})
+}
+
+/* Assembles the program read from reader into a relocatable object file for Link(). The file is
+assembled twice, with its labels placed from ROM address 0 and from ROM address 1: the words that
+differ by one hold ROM addresses that the linker moves along with the code, and a word that differs
+otherwise, such as @LOOP*2, is reported as an error. */
+func AssembleObject(reader io.Reader, options Options) (*Object, *SymbolTable, error) {
+	source, err1 := ioutil.ReadAll(reader) // the input is read once and assembled twice
+	if err1 != nil {
+		return nil, nil, err1
+	}
+	var listing bytes.Buffer
+	parser, words, symboltable, err2 := assemble(bytes.NewReader(source), options, true, 0, listingBuffer(&listing, options))
+	if err2 != nil {
+		return nil, nil, err2
+	}
+	var shiftedOptions Options = options
+	shiftedOptions.Optimizations = nil
+	_, shiftedWords, _, err3 := assemble(bytes.NewReader(source), shiftedOptions, true, 1, nil)
+	if err3 != nil {
+		return nil, nil, err3
+	}
+
+	var object *Object = &Object{Format: objectFormat, File: parser.fileName, Code: words, Exports: []ObjectSymbol{}, Imports: []ObjectSymbol{}, Variables: []ObjectSymbol{}}
+	var relocations []Relocation = parser.relocations
+	for address, word := range words {
+		if shiftedWords[address] == word {
+			continue
+		}
+		var source sourceLine = parser.commands[parser.wordCommands[address]]
+		if shiftedWords[address] == word+1 {
+			relocations = append(relocations, Relocation{Address: address, Kind: "rom", Line: source.lineNumber})
+		} else {
+			var indent int = len(source.text) - len(strings.TrimLeft(source.text, " \t"))
+			var command string = strings.TrimSpace(withoutComment(source.text))
+			parser.errorAtSource(source, indent+2, "'%s' cannot be relocated: its value does not move along with the code", command)
+		}
+	}
+	if len(parser.errors) > 0 {
+		return nil, nil, parser.errors
+	}
+	sort.SliceStable(relocations, func(i, j int) bool {
+		return relocations[i].Address < relocations[j].Address
+	})
+	object.Relocations = relocations
+
+	var variables map[string]bool = map[string]bool{}
+	for _, relocation := range relocations {
+		if relocation.Kind == "variable" && variables[relocation.Symbol] == false {
+			variables[relocation.Symbol] = true
+			object.Variables = append(object.Variables, ObjectSymbol{Name: relocation.Symbol, Line: relocation.Line})
+		}
+	}
+	for _, declaration := range parser.linkage {
+		var symbol ObjectSymbol = ObjectSymbol{Name: declaration.name, Line: declaration.source.lineNumber}
+		if declaration.directive == ".export" {
+			symbol.Address = symboltable.GetAddress(declaration.name)
+			symbol.Line = symboltable.Line(declaration.name) // the line of the (label), which the .sym file reports
+			object.Exports = append(object.Exports, symbol)
+		} else {
+			object.Imports = append(object.Imports, symbol)
+		}
+	}
+
+	if options.Listing != nil {
+		if _, err4 := options.Listing.Write(listing.Bytes()); err4 != nil {
+			return nil, nil, err4
+		}
+	}
+	return object, symboltable, nil
+}
+
+// Writes the object as JSON
+func WriteObject(writer io.Writer, object *Object) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(object)
+}
+
+// Reads an object file written by WriteObject(), checking that its relocations and exports lie within its code
+func ReadObject(reader io.Reader, fileName string) (*Object, error) {
+	var object Object
+	if err1 := json.NewDecoder(reader).Decode(&object); err1 != nil {
+		return nil, fmt.Errorf("%s: not an object file: %v", fileName, err1)
+	}
+	if object.Format != objectFormat {
+		return nil, fmt.Errorf("%s: unsupported object format '%s', expected '%s'", fileName, object.Format, objectFormat)
+	}
+	for _, relocation := range object.Relocations {
+		if relocation.Address < 0 || relocation.Address >= len(object.Code) {
+			return nil, fmt.Errorf("%s: relocation at ROM address %d lies outside the code", fileName, relocation.Address)
+		} else if relocation.Kind != "rom" && relocation.Kind != "import" && relocation.Kind != "variable" {
+			return nil, fmt.Errorf("%s: unknown relocation kind '%s'", fileName, relocation.Kind)
+		}
+	}
+	for _, export := range object.Exports {
+		if export.Address < 0 || export.Address > len(object.Code) {
+			return nil, fmt.Errorf("%s: exported label '%s' lies outside the code", fileName, export.Name)
+		}
+	}
+	return &object, nil
+}
@@ -0,0 +1,333 @@
+package hackasm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/* General description: "Removes redundant instructions from the expanded source before addresses
+are assigned to the labels." The optimizer only looks at instructions that follow each other
+without a label in between, and assumes that jumps reach labels or numeric ROM addresses. The code
+below the highest numeric address that the program jumps to, such as the bootstrap of Pong.asm
+that jumps to @133, is left in place so that those addresses keep pointing at the same
+instructions. A program that jumps to a constant or computes an address from a label, such as
+@LOOP+2, is left as it is. */
+
+// The instructions that the optimizer saved, per optimization
+type OptimizationReport struct {
+	Saved   map[string]int // "push-pop", "redundant-load" and "dead-store"
+	Skipped string         // why the program was left unoptimized, empty if it was optimized
+	Pinned  int            // the highest ROM address that the program jumps to or loads into D by number, below which the code was left in place
+}
+
+// Returns the number of instructions saved by every optimization together
+func (report OptimizationReport) Total() int {
+	var total int = 0
+	for _, saved := range report.Saved {
+		total = total + saved
+	}
+	return total
+}
+
+// Returns a summary such as "12 instructions saved (push-pop 8, redundant-load 4)"
+func (report OptimizationReport) String() string {
+	if report.Skipped != "" {
+		return "not optimized: " + report.Skipped
+	}
+	var names []string
+	for name := range report.Saved {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var counts []string
+	for _, name := range names {
+		counts = append(counts, fmt.Sprintf("%s %d", name, report.Saved[name]))
+	}
+	var summary string = "0 instructions saved"
+	if len(counts) > 0 {
+		summary = fmt.Sprintf("%d instructions saved (%s)", report.Total(), strings.Join(counts, ", "))
+	}
+	if report.Pinned > 0 {
+		summary = fmt.Sprintf("%s, ROM[0..%d] left in place for the numeric jump targets", summary, report.Pinned-1)
+	}
+	return summary
+}
+
+// Sequences that push D onto the stack, as VM translators write them
+var pushDSequences = [][]string{
+	{"@SP", "A=M", "M=D", "@SP", "M=M+1"},
+	{"@SP", "AM=M+1", "A=A-1", "M=D"},
+	{"@SP", "M=M+1", "A=M-1", "M=D"},
+}
+
+// Sequences that pop the top of the stack into D
+var popDSequences = [][]string{
+	{"@SP", "AM=M-1", "D=M"},
+	{"@SP", "M=M-1", "A=M", "D=M"},
+}
+
+/* Replaces the whole output of the preprocessor with its optimized version, which next() hands
+out instead. Both passes of the assembler optimize the same lines the same way. */
+func (preprocessor *Preprocessor) optimize() OptimizationReport {
+	var lines []sourceLine = make([]sourceLine, 0, preprocessor.inputLines())
+	for {
+		line, ok := preprocessor.next()
+		if ok == false {
+			break
+		}
+		lines = append(lines, line)
+	}
+	optimized, report := optimizeLines(lines)
+	preprocessor.pending = optimized
+	return report
+}
+
+/* Removes from lines the push of D that is followed at once by a pop into D, the loads of a value
+that A already holds, and the stores to M that are overwritten before anything reads them. The
+instructions below the highest numeric ROM address that the program jumps to are kept. */
+func optimizeLines(lines []sourceLine) ([]sourceLine, OptimizationReport) {
+	var report OptimizationReport = OptimizationReport{Saved: map[string]int{}}
+	var commands []string = make([]string, len(lines))
+	var instructions []int = make([]int, 0, len(lines)) // the lines that hold commands
+	var labels map[string]bool = map[string]bool{}
+	for index, line := range lines {
+		commands[index] = strings.TrimSpace(withoutComment(line.text))
+		if commands[index] != "" && strings.IndexAny(commands[index][:1], "@(.") < 0 && strings.ContainsAny(commands[index], " \t") {
+			commands[index] = strings.Join(strings.Fields(commands[index]), "") // a C-instruction such as D = M + 1
+		}
+		if commands[index] != "" {
+			instructions = append(instructions, index)
+		}
+		if strings.HasPrefix(commands[index], "(") && strings.HasSuffix(commands[index], ")") {
+			labels[commands[index][1:len(commands[index])-1]] = true
+		}
+	}
+	reason, pinned := unmovableAddress(lines, commands, instructions, labels)
+	if reason != "" {
+		report.Skipped = reason
+		return lines, report
+	}
+	if pinned > 0 {
+		report.Pinned = pinned
+		instructions = instructions[firstMovable(commands, instructions, pinned):] // the optimization starts afresh at the pinned address, as at a label
+	}
+
+	var removed []bool = make([]bool, len(lines))
+	for position := 0; position < len(instructions); position++ {
+		var length int = pushPopLength(commands, instructions, position)
+		if length == 0 {
+			continue
+		}
+		for offset := 0; offset < length; offset++ {
+			removed[instructions[position+offset]] = true
+		}
+		report.Saved["push-pop"] = report.Saved["push-pop"] + length
+		position = position + length - 1
+	}
+
+	var aValue string = ""    // the symbol or number that A holds, "" if it is not known
+	var pendingStore int = -1 // the last M=comp whose value nothing has read yet, -1 if none
+	for _, index := range instructions {
+		var command string = commands[index]
+		if removed[index] || strings.HasPrefix(command, ".") {
+			continue
+		} else if strings.HasPrefix(command, "@") {
+			var value string = command[1:]
+			if value != "" && value == aValue {
+				removed[index] = true
+				report.Saved["redundant-load"] = report.Saved["redundant-load"] + 1
+				continue
+			}
+			aValue = ""
+			if isSymbol(value) {
+				aValue = value
+			} else if _, isNumber := parseNumber(value); isNumber {
+				aValue = value
+			}
+			pendingStore = -1
+			continue
+		}
+
+		var scratch Parser = Parser{currentCommand: command}
+		destMnemonic, compMnemonic, jumpMnemonic := scratch.Dest(), scratch.Comp(), scratch.Jump()
+		_, ok1 := dest(destMnemonic)
+		_, ok2 := comp(compMnemonic)
+		_, ok3 := jump(jumpMnemonic)
+		if strings.HasPrefix(command, "(") || ok1 == false || ok2 == false || ok3 == false {
+			aValue = "" // a label, or an invalid command that is left for the assembler to report
+			pendingStore = -1
+			continue
+		}
+		if strings.Contains(compMnemonic, "M") {
+			pendingStore = -1
+		}
+		if strings.Contains(destMnemonic, "M") && pendingStore >= 0 {
+			removed[pendingStore] = true
+			report.Saved["dead-store"] = report.Saved["dead-store"] + 1
+		}
+		pendingStore = -1
+		if destMnemonic == "M" && jumpMnemonic == "" {
+			pendingStore = index
+		}
+		if strings.Contains(destMnemonic, "A") || jumpMnemonic != "" {
+			aValue = ""
+			pendingStore = -1
+		}
+	}
+
+	var optimized []sourceLine = lines[:0] // the kept lines move down in place
+	for index, line := range lines {
+		if removed[index] == false {
+			optimized = append(optimized, line)
+		}
+	}
+	return optimized, report
+}
+
+/* Returns the number of instructions of the push of D and the pop into D that start at position,
+or 0 if there are none. The pair is only removed when an A-instruction follows it, since the pop
+leaves the address of the stack top in A. */
+func pushPopLength(commands []string, instructions []int, position int) int {
+	for _, push := range pushDSequences {
+		if matchesSequence(commands, instructions, position, push) == false {
+			continue
+		}
+		for _, pop := range popDSequences {
+			var length int = len(push) + len(pop)
+			if matchesSequence(commands, instructions, position+len(push), pop) && position+length < len(instructions) && strings.HasPrefix(commands[instructions[position+length]], "@") {
+				return length
+			}
+		}
+	}
+	return 0
+}
+
+// Question: "Do the instructions from position on start with sequence?"
+func matchesSequence(commands []string, instructions []int, position int, sequence []string) bool {
+	if position+len(sequence) > len(instructions) {
+		return false
+	}
+	for offset, command := range sequence {
+		if commands[instructions[position+offset]] != command {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the number of words of machine code that a command takes: none for a label or a directive
+func commandWords(command string) int {
+	if command == "" || strings.HasPrefix(command, "(") || strings.HasPrefix(command, ".") {
+		return 0
+	} else if isPseudoInstruction(command) {
+		var words int = 0
+		name, operands := pseudoOperands(command)
+		for _, instruction := range pseudoExpansion(name, operands, 0) {
+			words = words + commandWords(instruction)
+		}
+		return words
+	}
+	return 1
+}
+
+// Returns the position in instructions of the first command whose ROM address is pinned or higher
+func firstMovable(commands []string, instructions []int, pinned int) int {
+	var romAddress int = 0
+	for position, index := range instructions {
+		if romAddress >= pinned {
+			return position
+		}
+		romAddress = romAddress + commandWords(commands[index])
+	}
+	return len(instructions)
+}
+
+/* Returns why removing instructions could change where the program jumps, or "" if it cannot:
+a jump to a constant, or an expression that computes an address from a label. Jumps to numbers
+and predefined symbols are allowed, and pinned is the highest ROM address they reach, 0 if there
+are none. When a jump takes its address from memory or from D, as RET does, a number or predefined
+symbol loaded into D, such as @12, D=A, may be the return address that it reaches, so it is pinned
+as well if it lies within the program. The pseudo-instructions are checked as the instructions
+they expand into. */
+func unmovableAddress(lines []sourceLine, commands []string, instructions []int, labels map[string]bool) (reason string, pinned int) {
+	var predefined *SymbolTable = InitSymbolTable()
+	var fixed map[string]bool = map[string]bool{} // names of constants and predefined symbols
+	for symbol := range InitSymbolTable().symbols {
+		fixed[symbol] = true
+	}
+	for _, index := range instructions {
+		if strings.HasPrefix(commands[index], ".") == false {
+			continue
+		}
+		if fields := strings.Fields(commands[index]); len(fields) > 1 && (fields[0] == ".equ" || fields[0] == ".define") {
+			fixed[fields[1]] = true
+		}
+	}
+
+	var expanded []string = make([]string, 0, len(instructions)) // the commands, with every pseudo-instruction replaced by its expansion
+	var lineNumbers []int = make([]int, 0, len(instructions))    // the line of each of them
+	for _, index := range instructions {
+		var command string = strings.TrimSpace(withoutComment(lines[index].text))
+		if isPseudoInstruction(command) {
+			name, operands := pseudoOperands(command)
+			for _, instruction := range pseudoExpansion(name, operands, 0) {
+				expanded = append(expanded, instruction)
+				lineNumbers = append(lineNumbers, lines[index].lineNumber)
+			}
+			continue
+		}
+		expanded = append(expanded, commands[index])
+		lineNumbers = append(lineNumbers, lines[index].lineNumber)
+	}
+
+	var programWords int = 0
+	for _, index := range instructions {
+		programWords = programWords + commandWords(commands[index])
+	}
+	var jumpsThroughMemory bool = false // whether a jump takes its address from anything but the A-instruction right before it
+	for position, command := range expanded {
+		var scratch Parser = Parser{currentCommand: command}
+		if strings.ContainsAny(command[:1], "@(.") == false && scratch.Jump() != "" && (position == 0 || strings.HasPrefix(expanded[position-1], "@") == false) {
+			jumpsThroughMemory = true
+			break
+		}
+	}
+	for position, command := range expanded {
+		if strings.HasPrefix(command, "@") == false {
+			continue
+		}
+		var value string = command[1:]
+		var symbolic bool = isSymbol(value) || isNumericReference(value) // a numeric reference such as 1f loads a label
+		if symbolic == false {
+			for _, name := range expressionNames(value) {
+				if labels[name] {
+					return fmt.Sprintf("line %d computes an address from the label '%s'", lineNumbers[position], name), 0
+				}
+			}
+		}
+		if position+1 < len(expanded) && (symbolic == false || fixed[value]) {
+			var scratch Parser = Parser{currentCommand: expanded[position+1]}
+			if strings.ContainsAny(scratch.currentCommand[:1], "@(.") {
+				continue
+			}
+			var target int64 = -1
+			if number, isNumber := parseNumber(value); isNumber {
+				target = number
+			} else if predefined.Contains(value) && labels[value] == false {
+				target = int64(predefined.GetAddress(value))
+			}
+			if scratch.Jump() != "" {
+				if target < 0 || target > 32767 {
+					return fmt.Sprintf("line %d jumps to '%s', which is neither a label nor a number", lineNumbers[position+1], value), 0
+				}
+				if int(target) > pinned {
+					pinned = int(target)
+				}
+			} else if jumpsThroughMemory && strings.Contains(scratch.Dest(), "D") && strings.Contains(scratch.Comp(), "A") && target >= 0 && target < int64(programWords) && int(target) > pinned {
+				pinned = int(target) // Case: a return address such as @12, D=A, stored for a jump through memory later
+			}
+		}
+	}
+	return "", pinned
+}
@@ -0,0 +1,170 @@
+package hackasm
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestOptimize(t *testing.T) {
+	var tests = []struct {
+		source   string
+		expected string // the program that the optimized source assembles into
+		report   string
+	}{
+		{"@SP\nAM=M+1\nA=A-1\nM=D\n@SP\nAM=M-1\nD=M\n@x\nM=D\n", "@x\nM=D\n", "7 instructions saved (push-pop 7)"},
+		{"@SP\nA=M\nM=D\n@SP\nM=M+1\n@SP\nM=M-1\nA=M\nD=M\n@x\nM=D\n", "@x\nM=D\n", "9 instructions saved (push-pop 9)"},
+		{"@x\nD=M\n@x\nM=D+1\n", "@x\nD=M\nM=D+1\n", "1 instructions saved (redundant-load 1)"},
+		{"@x\nM=0\nM=1\n", "@x\nM=1\n", "1 instructions saved (dead-store 1)"},
+		{"@x\nD=M\n(LOOP)\n@x\nM=D+1\n@LOOP\n0;JMP\n", "@x\nD=M\n(LOOP)\n@x\nM=D+1\n@LOOP\n0;JMP\n", "0 instructions saved"},
+		{"@x\nM=0\nD=M\nM=1\n", "@x\nM=0\nD=M\nM=1\n", "0 instructions saved"},
+		{"@x\nD=M\n@x\nD;JGT\n@x\n0;JMP\n", "@x\nD=M\nD;JGT\n@x\n0;JMP\n", "1 instructions saved (redundant-load 1)"},
+		{"@4\n0;JMP\n@x\nD=M\n@x\nM=D\n@x\nD=M\n@x\nM=D\n", "@4\n0;JMP\n@x\nD=M\n@x\nM=D\nD=M\nM=D\n", "2 instructions saved (redundant-load 2), ROM[0..3] left in place for the numeric jump targets"},
+		{"@R15\n0;JMP\n@x\nD=M\n@x\nM=D\n", "@R15\n0;JMP\n@x\nD=M\n@x\nM=D\n", "0 instructions saved, ROM[0..14] left in place for the numeric jump targets"},
+		{"@11\nD=A\n@R15\nM=D\n@x\nD=M\n@x\nM=D\n@R15\nA=M\n0;JMP\n@y\nM=1\n", "@11\nD=A\n@R15\nM=D\n@x\nD=M\n@x\nM=D\n@R15\nA=M\n0;JMP\n@y\nM=1\n", "0 instructions saved, ROM[0..10] left in place for the numeric jump targets"},
+		{"@100\nD=A\n@R15\nM=D\n@x\nD=M\n@x\nM=D\n@R15\nA=M\n0;JMP\n", "@100\nD=A\n@R15\nM=D\n@x\nD=M\nM=D\n@R15\nA=M\n0;JMP\n", "1 instructions saved (redundant-load 1)"},
+		{"@5\nD=A\n@x\nM=D\n@x\nD=M\n", "@5\nD=A\n@x\nM=D\nD=M\n", "1 instructions saved (redundant-load 1)"},
+		{".equ START 2\n@START\n0;JMP\n@x\nD=M\n@x\nM=D\n", ".equ START 2\n@START\n0;JMP\n@x\nD=M\n@x\nM=D\n", "not optimized: line 3 jumps to 'START', which is neither a label nor a number"},
+		{"(LOOP)\n@x\nD=M\n@x\nM=D\n@LOOP+2\n0;JMP\n", "(LOOP)\n@x\nD=M\n@x\nM=D\n@LOOP+2\n0;JMP\n", "not optimized: line 6 computes an address from the label 'LOOP'"},
+	}
+	for _, test := range tests {
+		var report OptimizationReport
+		words, _ := mustAssemble(t, test.source, Options{FileName: "O.asm", Optimize: true, Optimizations: &report})
+		expected, _ := mustAssemble(t, test.expected, Options{FileName: "Expected.asm"})
+		if hackText(t, words) != hackText(t, expected) {
+			t.Errorf("%q:\ngot      %q\nexpected %q", test.source, Disassemble(words, false), Disassemble(expected, false))
+		}
+		if report.String() != test.report {
+			t.Errorf("%q: reported %q, expected %q", test.source, report.String(), test.report)
+		}
+	}
+}
+
+// A Hack computer that runs the machine code of a program, with no key pressed
+type hackMachine struct {
+	rom []uint16
+	ram []uint16
+	a   uint16
+	d   uint16
+	pc  uint16
+}
+
+func newHackMachine(rom []uint16) *hackMachine {
+	return &hackMachine{rom: rom, ram: make([]uint16, 32768)}
+}
+
+// Runs the instruction at the PC
+func (machine *hackMachine) step() {
+	var word uint16 = machine.rom[machine.pc]
+	if word&0x8000 == 0 {
+		machine.a = word
+		machine.pc = machine.pc + 1
+		return
+	}
+	var x, y uint16 = machine.d, machine.a
+	if word&0x1000 != 0 {
+		y = machine.ram[machine.a&0x7FFF]
+	}
+	if word&0x0800 != 0 { // zx
+		x = 0
+	}
+	if word&0x0400 != 0 { // nx
+		x = ^x
+	}
+	if word&0x0200 != 0 { // zy
+		y = 0
+	}
+	if word&0x0100 != 0 { // ny
+		y = ^y
+	}
+	var out uint16 = x & y
+	if word&0x0080 != 0 { // f
+		out = x + y
+	}
+	if word&0x0040 != 0 { // no
+		out = ^out
+	}
+	var address uint16 = machine.a
+	if word&0x0008 != 0 {
+		machine.ram[address&0x7FFF] = out
+	}
+	if word&0x0020 != 0 {
+		machine.a = out
+	}
+	if word&0x0010 != 0 {
+		machine.d = out
+	}
+	var value int16 = int16(out)
+	if (word&0x4 != 0 && value < 0) || (word&0x2 != 0 && value == 0) || (word&0x1 != 0 && value > 0) {
+		machine.pc = address
+	} else {
+		machine.pc = machine.pc + 1
+	}
+}
+
+// Runs the machine until it reaches an address in labels, and returns the names declared there
+func (machine *hackMachine) runToLabel(labels map[int]string) string {
+	for {
+		machine.step()
+		if names, ok := labels[int(machine.pc)]; ok {
+			return names
+		}
+	}
+}
+
+// Returns the names of the labels at each ROM address, and the address of each label
+func labelAddresses(symboltable *SymbolTable) (map[int]string, map[string]int) {
+	var names map[int][]string = map[int][]string{}
+	var addresses map[string]int = map[string]int{}
+	for symbol, kind := range symboltable.kinds {
+		if kind == LABEL_SYMBOL {
+			names[symboltable.GetAddress(symbol)] = append(names[symboltable.GetAddress(symbol)], symbol)
+			addresses[symbol] = symboltable.GetAddress(symbol)
+		}
+	}
+	var joined map[int]string = map[int]string{}
+	for address, symbols := range names {
+		sort.Strings(symbols)
+		joined[address] = strings.Join(symbols, ",")
+	}
+	return joined, addresses
+}
+
+/* Runs Pong.asm with and without the optimizer side by side, one label at a time. Both must pass
+the same labels in the same order, with the same RAM whenever they reach one, except for the
+garbage above the stack top and the return addresses that moved along with the code. */
+func TestOptimizeKeepsPongBehavior(t *testing.T) {
+	var source string = string(readAsmFile(t, "Pong", ".asm"))
+	plainWords, plainTable := mustAssemble(t, source, Options{FileName: "Pong.asm"})
+	var report OptimizationReport
+	optimizedWords, optimizedTable := mustAssemble(t, source, Options{FileName: "Pong.asm", Optimize: true, Optimizations: &report})
+	if report.Skipped != "" || report.Total() == 0 || len(optimizedWords) != len(plainWords)-report.Total() {
+		t.Fatalf("Pong.asm: %v, %d words instead of %d", report, len(optimizedWords), len(plainWords))
+	}
+	plainLabels, _ := labelAddresses(plainTable)
+	optimizedLabels, optimizedAddresses := labelAddresses(optimizedTable)
+	var movedTo map[uint16]uint16 = map[uint16]uint16{} // the optimized address of every label address
+	for address, names := range plainLabels {
+		movedTo[uint16(address)] = uint16(optimizedAddresses[strings.Split(names, ",")[0]])
+	}
+
+	var plain, optimized *hackMachine = newHackMachine(plainWords), newHackMachine(optimizedWords)
+	for event := 0; event < 200000; event++ {
+		var plainLabel, optimizedLabel string = plain.runToLabel(plainLabels), optimized.runToLabel(optimizedLabels)
+		if plainLabel != optimizedLabel {
+			t.Fatalf("label %d: reached %s with -O, expected %s", event, optimizedLabel, plainLabel)
+		}
+		if event%1000 != 0 {
+			continue
+		}
+		for address := 0; address < 24576; address++ {
+			var expected, got uint16 = plain.ram[address], optimized.ram[address]
+			if got == expected || (address >= int(plain.ram[0]) && address < 2048) {
+				continue // the stack above its top holds garbage
+			} else if moved, ok := movedTo[expected]; ok && moved == got {
+				continue // a return address
+			}
+			t.Fatalf("at label %s (%d): RAM[%d] is %d with -O, expected %d", plainLabel, event, address, got, expected)
+		}
+	}
+}
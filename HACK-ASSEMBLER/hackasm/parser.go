@@ -0,0 +1,413 @@
+package hackasm
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+/* General description: "Encapsulates access to the input code.
+Reads an assembly language command, parses it, and provides convenient access to command's components (fields and symbols).
+Removes all white space and comments" */
+const (
+	A_COMMAND         = 0
+	C_COMMAND         = 1
+	L_COMMAND         = 2
+	DIRECTIVE_COMMAND = 3 // .equ NAME expression, or its synonym .define NAME expression
+	PSEUDO_COMMAND    = 4 // a pseudo-instruction such as PUSH D, followed by the commands it expands into
+)
+
+// A constant declared by .equ or .define, evaluated once addLCOMMAND() has collected the labels
+type constantDefinition struct {
+	name       string
+	expression string
+	source     sourceLine // line of the directive
+	column     int        // column of the expression
+	state      int        // 0 before evaluation, 1 during evaluation, 2 after evaluation
+	value      int
+	ok         bool
+}
+
+type Parser struct {
+	fileName       string
+	preprocessor   *Preprocessor
+	currentSource  sourceLine
+	currentCommand string
+	currentCode    string // the current command as HasMoreCommands() found it, without its comment and the spaces around it
+	commandType    int    // the type of the current command, worked out by Advance()
+	spacedCommand  string // the current C-command as written, if it holds spaces that currentCommand leaves out
+	lineNumber     int    // line of the current command, starting from 1
+	column         int // column where the current command starts, starting from 1
+	ramAddress     int
+	constants      []*constantDefinition
+	errors         ErrorList
+	relocatable    bool                 // whether the file is assembled into an object file for the linker
+	instructionset *InstructionSet      // the mnemonics that C-instructions may use
+	aliases        bool                 // whether operands and destinations may be written in another order
+	linkage        []linkageDeclaration // the .export and .import declarations
+	relocations    []Relocation         // the A-instructions that load symbols defined by other files
+	wordCommands   []int                // index in commands of the command that generated each word
+	wordLabels     []string             // nearest label at or before each generated word, "" if none
+	commands       []sourceLine         // the commands read by the first pass, which the second pass reads again
+	codes          []string             // the code of each of the commands, so that later passes need not strip the comments again
+	replaying      bool                 // whether the commands come from commands instead of the input
+	replayed       int                  // number of commands read again so far
+	localNames     map[int]string       // the full names of the local labels that commands declare or load, by index in commands
+	pseudo         bool                 // whether pseudo-instructions such as PUSH D are expanded
+	expansion      []sourceLine         // the lines of the current pseudo-instruction still to be handed out
+	calls          int                  // number of CALL pseudo-instructions expanded so far
+	cWords         map[string]uint16    // the word of every valid C-command encoded so far, as programs repeat a few of them many times
+
+	maxROMWords        int // the most words of machine code, 0 for no limit
+	maxVariableAddress int // the highest RAM address of a variable, 0 for no limit
+}
+
+/* Returns a parser of the commands read from file, which is read only once: the commands are kept in
+memory for a second pass, so file may be a pipe such as the standard input. */
+func InitParser(file io.Reader, fileName string) *Parser {
+	preprocessor := initPreprocessor(file, fileName)
+	var parser Parser = Parser{fileName: fileName, preprocessor: preprocessor, ramAddress: 0, instructionset: standardInstructionSet(), localNames: map[int]string{}}
+	return &parser
+}
+
+/* Makes the parser validate and encode C-instructions against the given instruction set instead of
+the standard one. With aliases set, operands and destinations in another order, such as M+D or DM,
+are accepted as well. */
+func (parser *Parser) SetInstructionSet(instructionset *InstructionSet, aliases bool) {
+	parser.instructionset = instructionset
+	parser.aliases = aliases
+}
+
+// Returns the line of the current command, starting from 1
+func (parser *Parser) LineNumber() int {
+	return parser.lineNumber
+}
+
+// Returns the errors found in the commands read so far
+func (parser *Parser) Errors() ErrorList {
+	return parser.errors
+}
+
+/* Records an error at the given column of the current line. Errors in the expansion of a macro
+are reported at the call site, along with the line of the definition that holds the command. */
+func (parser *Parser) errorAt(column int, format string, args ...interface{}) {
+	parser.errorAtSource(parser.currentSource, column, format, args...)
+}
+
+// Records an error at the given column of source
+func (parser *Parser) errorAtSource(source sourceLine, column int, format string, args ...interface{}) {
+	var message string = fmt.Sprintf(format, args...)
+	if source.pseudo != "" {
+		var command string = strings.TrimSpace(withoutComment(source.pseudo))
+		message = fmt.Sprintf("%s (in the expansion of '%s')", message, command)
+		column = strings.Index(source.pseudo, command) + 1
+	}
+	if source.macro != nil {
+		message = fmt.Sprintf("%s (expanded from macro %s at %s:%d:%d)", message, source.macro.name, source.macro.file, source.bodyLine, column)
+		column = source.column
+	}
+	var file string = source.file
+	if file == "" {
+		file = parser.fileName
+	}
+	var err *SourceError = &SourceError{File: file, Line: source.lineNumber, Column: column, Message: message}
+	parser.errors = append(parser.errors, err)
+}
+
+// Question: "Are there more commands in the input?"
+func (parser *Parser) HasMoreCommands() bool {
+	if parser.replaying {
+		if parser.replayed >= len(parser.commands) {
+			return false
+		}
+		parser.currentSource = parser.commands[parser.replayed]
+		parser.currentCode = parser.codes[parser.replayed]
+		parser.lineNumber = parser.currentSource.lineNumber
+		parser.replayed = parser.replayed + 1
+		return true
+	}
+	for {
+		var source sourceLine
+		if len(parser.expansion) > 0 {
+			source = parser.expansion[0]
+			parser.expansion = parser.expansion[1:]
+		} else if next, ok := parser.preprocessor.next(); ok {
+			source = next
+		} else {
+			return false
+		}
+		var code string = strings.TrimSpace(withoutComment(source.text))
+		if code == "" { // Case: this line is empty or holds only a comment
+			continue
+		}
+		if parser.commands == nil { // room for every line of the input, which most programs hold without macros
+			parser.commands = make([]sourceLine, 0, parser.preprocessor.inputLines())
+			parser.codes = make([]string, 0, parser.preprocessor.inputLines())
+		}
+		parser.currentSource = source
+		parser.currentCode = code
+		parser.lineNumber = source.lineNumber
+		parser.commands = append(parser.commands, source)
+		parser.codes = append(parser.codes, code)
+		if parser.pseudo && source.pseudo == "" && isPseudoInstruction(code) {
+			parser.expansion = parser.expandPseudo(source, code)
+		}
+		return true
+	}
+}
+
+/* Starts another pass over the commands read so far, which the parser hands out again from memory
+in the same order, with the macros expanded and the optimizations applied as before. */
+func (parser *Parser) rewind() {
+	parser.replaying = true
+	parser.replayed = 0
+	parser.currentCommand = ""
+	parser.lineNumber = 0
+}
+
+/* "Reads the next command from the input and makes it the current command.
+Should be called only if hasMoreCommands() is true.
+Initially there is no curent command."
+*/
+func (parser *Parser) Advance() {
+	var inputCommand string = parser.currentSource.text // Read next command
+	parser.currentCommand = parser.currentCode
+	parser.column = leadingSpaces(inputCommand) + 1 // the lexer leaves no other space before a command
+	parser.commandType = parser.typeOf(parser.currentCommand)
+	parser.spacedCommand = ""
+	if parser.commandType == C_COMMAND && (strings.IndexByte(parser.currentCommand, ' ') >= 0 || strings.IndexByte(parser.currentCommand, '\t') >= 0) { // Case: D = M + 1
+		parser.spacedCommand = parser.currentCommand
+		parser.currentCommand = strings.Join(strings.Fields(parser.currentCommand), "")
+	}
+}
+
+// Returns the source text of the current command, including its comment
+func (parser *Parser) currentLine() string {
+	return strings.TrimRight(parser.currentSource.text, " \t\r")
+}
+
+/* "Returns the type of the current command:
+A_COMMAND for @Xxx whjere Xxx is either a symbol or a decimal number
+C_COMMAND for dest=comp;jump
+L_COMMAND (actually, pseudo-command) for (Xxx) where Xxx is a symbol"
+DIRECTIVE_COMMAND for .equ NAME expression and .define NAME expression
+PSEUDO_COMMAND for a pseudo-instruction such as PUSH D, when they are enabled
+*/
+func (parser *Parser) CommandType() int {
+	return parser.commandType
+}
+
+// Returns the type of command, as described for CommandType()
+func (parser *Parser) typeOf(command string) int {
+	if strings.HasPrefix(command, "@") {
+		return A_COMMAND
+	} else if strings.HasPrefix(command, "(") { // an unbalanced "(Xxx" is reported by addLCOMMAND
+		return L_COMMAND
+	} else if strings.HasPrefix(command, ".") {
+		return DIRECTIVE_COMMAND
+	} else if parser.pseudo && isPseudoInstruction(command) {
+		return PSEUDO_COMMAND
+	} else {
+		return C_COMMAND
+	}
+}
+
+/* "Returns the symbol or decimal Xxx of the current command @Xxx or (Xxx).
+Should be called only when commandType() is A_COMMAND or L_COMMAND."
+Local labels such as .loop and 1f are returned by their full names once addLCOMMAND() has seen them.
+*/
+func (parser *Parser) Symbol() string {
+	if len(parser.localNames) > 0 {
+		if fullName, ok := parser.localNames[parser.commandIndex()]; ok {
+			return fullName
+		}
+	}
+	var commandtype int = parser.CommandType()
+	if commandtype == A_COMMAND {
+		var val string = parser.currentCommand[1:] // without the leading "@"
+		return val
+	} else if commandtype == L_COMMAND {
+		var val string = parser.currentCommand[1:] // without the leading "(" and the first ")"
+		if closing := strings.Index(val, ")"); closing == len(val)-1 {
+			val = val[:closing]
+		} else if closing >= 0 {
+			val = val[:closing] + val[closing+1:]
+		}
+		return val
+	} else { // C_COMMAND does not consist of a symbol
+		return parser.currentCommand
+	}
+
+}
+
+/* "Returns the dest mnemonic in the current C-command (8 possibilities)
+Should be called only when commandType is C_COMMAND."
+*/
+func (parser *Parser) Dest() string {
+	if strings.Contains(parser.currentCommand, "=") {
+		return parser.currentCommand[:strings.Index(parser.currentCommand, "=")]
+	} else {
+		return ""
+	}
+}
+
+/* "Returns the comp mnemonic in the current C-command (28 possibilities).
+Should be called only when commandType() is C_COMMAND."
+*/
+func (parser *Parser) Comp() string {
+	var comp string = parser.currentCommand[parser.compOffset():]
+	if end := strings.Index(comp, ";"); end >= 0 {
+		return comp[:end]
+	}
+	return comp
+}
+
+/* "Returns the jump mnemonic in the current C-Command (8 possiblities).
+Should be called only when commandType() is C_COMMAND."
+*/
+func (parser *Parser) Jump() string {
+	if strings.Contains(parser.currentCommand, ";") {
+		return parser.currentCommand[parser.jumpOffset():]
+	} else {
+		return ""
+	}
+}
+
+// Returns the column of the character at offset in the current command, counting the spaces that it was written with
+func (parser *Parser) commandColumn(offset int) int {
+	var kept int = 0
+	for index := 0; index < len(parser.spacedCommand); index++ {
+		if parser.spacedCommand[index] == ' ' || parser.spacedCommand[index] == '\t' {
+			continue
+		} else if kept == offset {
+			return parser.column + index
+		}
+		kept = kept + 1
+	}
+	return parser.column + offset
+}
+
+// Returns the offset of the comp field within the current C-command
+func (parser *Parser) compOffset() int {
+	return strings.Index(parser.currentCommand, "=") + 1
+}
+
+// Returns the offset of the jump field within the current C-command
+func (parser *Parser) jumpOffset() int {
+	return strings.Index(parser.currentCommand, ";") + 1
+}
+
+// Question: "Is name a valid symbol?" Symbols consist of letters, digits, '_', '.', '$' and ':', and do not begin with a digit.
+func isSymbol(name string) bool {
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		return false
+	}
+	for _, char := range name {
+		var isLetter bool = (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z')
+		var isDigit bool = char >= '0' && char <= '9'
+		if isLetter == false && isDigit == false && strings.ContainsRune("_.$:", char) == false {
+			return false
+		}
+	}
+	return true
+}
+
+/* Returns the address in the current A-command, which is either a constant (@42, @0x4000, @0b1010,
+@'A'), a constant declared by .equ, or an expression such as @SCREEN+32*row, @LOOP+2 or @(KBD-1).
+A negative value down to -16384 is loaded as its 15-bit two's complement, so @-1 loads 0x7FFF.
+Reports malformed expressions and values that do not fit in 15 bits. ok is false for the other
+symbols, which are labels, predefined symbols or variables. */
+func (parser *Parser) constant(symboltable *SymbolTable) (address int, ok bool) {
+	var symbol string = parser.Symbol()
+	var column int = parser.column + 1
+	if symbol == "" {
+		parser.errorAt(parser.column, "expected a symbol or a constant after '@'")
+		return 0, true
+	}
+	if isSymbol(symbol) && symboltable.kinds[symbol] != CONSTANT_SYMBOL {
+		return 0, false
+	}
+	if symbol[0] >= '0' && symbol[0] <= '9' {
+		if value, err := strconv.Atoi(symbol); err == nil && value <= 32767 { // Case: a decimal number such as @256, most of the constants
+			return value, true
+		}
+	}
+
+	value, err := evaluate(symbol, func(name string) (int, bool) {
+		if symboltable.Contains(name) {
+			symboltable.markUsed(name, parser.lineNumber)
+			return symboltable.GetAddress(name), true
+		}
+		return 0, false
+	})
+	if err != nil {
+		parser.errorAt(column+err.offset, "%s", err.message)
+	} else if value < -16384 {
+		parser.errorAt(column, "value %d of '%s' does not fit in 15 bits", value, symbol)
+	} else if value < 0 {
+		value = value & 0x7FFF
+	} else if value > 32767 {
+		parser.errorAt(column, "value %d of '%s' does not fit in 15 bits", value, symbol)
+	}
+	return value, true
+}
+
+/* Returns the name and the expression of the current directive, e.g. "ROWS" and "32" for .equ ROWS 32.
+Should be called only when commandType() is DIRECTIVE_COMMAND. */
+func (parser *Parser) directive() (directive string, name string, expression string, expressionColumn int) {
+	var command string = parser.currentCommand
+	var end int = strings.IndexAny(command, " \t")
+	if end < 0 {
+		return command, "", "", parser.column + len(command)
+	}
+	directive = command[:end]
+	var rest string = strings.TrimLeft(command[end:], " \t")
+	var nameEnd int = strings.IndexAny(rest, " \t,")
+	if nameEnd < 0 {
+		return directive, rest, "", parser.column + len(command)
+	}
+	name = rest[:nameEnd]
+	var afterName string = strings.TrimLeft(rest[nameEnd:], " \t,")
+	return directive, name, afterName, parser.column + len(command) - len(afterName)
+}
+
+// Returns the word of the current C-command, 1 followed by its comp, dest and jump bits, reporting every unknown mnemonic
+func (parser *Parser) cCommand() uint16 {
+	if word, ok := parser.cWords[parser.currentCommand]; ok {
+		return word
+	}
+	destCode, ok1 := parser.instructionset.dest(parser.Dest(), parser.aliases)
+	compCode, ok2 := parser.instructionset.comp(parser.Comp(), parser.aliases)
+	jumpCode, ok3 := parser.instructionset.jump(parser.Jump())
+	if ok1 && ok2 && ok3 {
+		if parser.cWords == nil {
+			parser.cWords = map[string]uint16{}
+		}
+		parser.cWords[parser.currentCommand] = 1<<15 | bits(compCode)<<6 | bits(destCode)<<3 | bits(jumpCode)
+		return parser.cWords[parser.currentCommand]
+	}
+	if isPseudoInstruction(parser.spacedCommand) || isPseudoInstruction(parser.currentCommand) {
+		parser.errorAt(parser.column, "pseudo-instruction '%s' is not enabled", strings.TrimSpace(withoutComment(parser.currentSource.text)))
+		return 0
+	}
+	if ok1 == false {
+		parser.errorAt(parser.column, "unknown dest '%s'", parser.Dest())
+	}
+	if ok2 == false {
+		parser.errorAt(parser.commandColumn(parser.compOffset()), "unknown comp '%s'", parser.Comp())
+	}
+	if ok3 == false {
+		parser.errorAt(parser.commandColumn(parser.jumpOffset()), "unknown jump '%s'", parser.Jump())
+	}
+	return 0
+}
+
+// Returns the value of a code of binary digits such as "0101010", 0 for the empty code of an unknown mnemonic
+func bits(code string) uint16 {
+	var value uint16 = 0
+	for index := 0; index < len(code); index++ {
+		value = value<<1 | uint16(code[index]-'0')
+	}
+	return value
+}
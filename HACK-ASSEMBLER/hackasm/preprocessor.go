@@ -0,0 +1,420 @@
+package hackasm
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+/* General description of the Preprocessor struct & functions: "Expands the macros of the input code
+before the Parser sees it." A macro is defined by
+
+	.macro NAME param1, param2
+	    ...
+	.endm
+
+and called as "NAME arg1, arg2". In the body, \param stands for the argument and %%label for a
+label that is unique to each expansion. Macros must be defined before they are called.
+
+The directive .include "path" reads the lines of another file in its place, so macros and constants
+can be shared between programs. A relative path is resolved against the directory of the file that
+holds the directive. */
+
+// A line of source text handed to the Parser, along with where it came from
+type sourceLine struct {
+	text       string
+	file       string // the file that holds the line; for expanded lines, the file of the outermost macro call
+	lineNumber int    // line of the file; for expanded lines, the line of the outermost macro call
+	column     int    // for expanded lines, the column of the outermost macro call
+	macro      *macro // the macro whose body holds the text, nil outside expansions
+	bodyLine   int    // for expanded lines, the line of the definition that holds the text
+	depth      int    // number of nested expansions that produced the line
+	pseudo     string // for the lines that a pseudo-instruction expands into, the line of the pseudo-instruction
+}
+
+type macro struct {
+	name       string
+	parameters []string
+	body       []string
+	file       string // the file that holds the definition
+	bodyLines  []int  // line of each body line in that file
+	lineNumber int    // line of the .macro directive
+}
+
+const maxMacroDepth = 16
+
+const maxIncludeDepth = 16
+
+/* A file being read by the Preprocessor: the input, or a file included by .include. The whole file
+is read at once, and its lines are slices of source, so reading a line allocates nothing. */
+type inputFile struct {
+	source        string
+	offset        int // where the next line of source starts
+	name          string
+	lineNumber    int
+	inComment     bool // whether a block comment is open at the end of the last line read
+	commentLine   int  // the line and column where the open block comment starts
+	commentColumn int
+}
+
+type Preprocessor struct {
+	files      []*inputFile // the input and the files included from it, the file being read last
+	macros     map[string]*macro
+	pending    []sourceLine // lines of the expansions still to be handed out
+	expansions int          // number of expansions so far, used to make local labels unique
+	included   []string     // the paths of the files named by .include, in the order they were first included
+	readError  error        // the error of reading the input
+	errors     ErrorList
+}
+
+func initPreprocessor(reader io.Reader, fileName string) *Preprocessor {
+	source, err := readAll(reader)
+	var input *inputFile = &inputFile{source: source, name: fileName}
+	return &Preprocessor{files: []*inputFile{input}, macros: map[string]*macro{}, readError: err}
+}
+
+// Reads the whole input into a string, reserving room for it at once when the reader tells its size
+func readAll(reader io.Reader) (string, error) {
+	var builder strings.Builder
+	if sized, ok := reader.(interface{ Len() int }); ok { // Case: a strings.Reader or a bytes.Reader
+		builder.Grow(sized.Len())
+	} else if file, ok := reader.(*os.File); ok {
+		if info, err1 := file.Stat(); err1 == nil && info.Mode().IsRegular() {
+			builder.Grow(int(info.Size()))
+		}
+	}
+	_, err2 := io.Copy(&builder, reader)
+	return builder.String(), err2
+}
+
+// Returns an estimate of the number of lines of the input, which the Parser reserves room for
+func (preprocessor *Preprocessor) inputLines() int {
+	return strings.Count(preprocessor.files[0].source, "\n") + 1
+}
+
+// Returns the next line of the file without its line ending, and false at its end
+func (current *inputFile) nextLine() (string, bool) {
+	if current.offset >= len(current.source) {
+		return "", false
+	}
+	var rest string = current.source[current.offset:]
+	var end int = strings.IndexByte(rest, '\n')
+	if end < 0 {
+		current.offset = len(current.source)
+		return strings.TrimSuffix(rest, "\r"), true
+	}
+	current.offset = current.offset + end + 1
+	return strings.TrimSuffix(rest[:end], "\r"), true
+}
+
+// Records an error at the given line and column of a file
+func (preprocessor *Preprocessor) errorAt(file string, lineNumber int, column int, format string, args ...interface{}) {
+	var err *SourceError = &SourceError{File: file, Line: lineNumber, Column: column, Message: fmt.Sprintf(format, args...)}
+	preprocessor.errors = append(preprocessor.errors, err)
+}
+
+/* Returns the next line of the file being read, cleaned up as described in lexer.go, and false at
+the end of that file. A line that holds a stray character is reported and handed out empty. */
+func (preprocessor *Preprocessor) readLine() (sourceLine, bool) {
+	var current *inputFile = preprocessor.files[len(preprocessor.files)-1]
+	text, ok := current.nextLine()
+	if ok == false {
+		if current.inComment {
+			preprocessor.errorAt(current.name, current.commentLine, current.commentColumn, "unterminated block comment")
+			current.inComment = false
+		}
+		return sourceLine{}, false
+	}
+	current.lineNumber = current.lineNumber + 1
+	if current.lineNumber == 1 {
+		text = strings.TrimPrefix(text, byteOrderMark)
+	}
+	var opened int
+	text, current.inComment, opened = stripBlockComments(text, current.inComment)
+	if opened >= 0 && current.inComment {
+		current.commentLine = current.lineNumber
+		current.commentColumn = opened + 1
+	}
+	if stray, description := strayCharacter(withoutComment(text)); stray >= 0 {
+		preprocessor.errorAt(current.name, current.lineNumber, stray+1, "stray character %s", description)
+		text = ""
+	}
+	return sourceLine{text: text, file: current.name, lineNumber: current.lineNumber}, true
+}
+
+// Leaves the included file that has been read to the end and returns to the file that included it, if there is one
+func (preprocessor *Preprocessor) closeInclude() bool {
+	if len(preprocessor.files) == 1 {
+		return false
+	}
+	preprocessor.files = preprocessor.files[:len(preprocessor.files)-1]
+	return true
+}
+
+// Returns text without its comment
+func withoutComment(text string) string {
+	if comment := strings.Index(text, "//"); comment >= 0 {
+		return text[:comment]
+	}
+	return text
+}
+
+// Returns the fields of a line, ignoring its comment
+func lineFields(text string) []string {
+	return strings.Fields(withoutComment(text))
+}
+
+// Returns the first field of a line, ignoring its comment, or "" if the line holds no command
+func firstField(text string) string {
+	var code string = strings.TrimSpace(withoutComment(text))
+	if end := strings.IndexFunc(code, unicode.IsSpace); end >= 0 {
+		return code[:end]
+	}
+	return code
+}
+
+// Returns the next line for the Parser, with every macro definition removed and every macro call expanded
+func (preprocessor *Preprocessor) next() (sourceLine, bool) {
+	for {
+		var line sourceLine
+		if len(preprocessor.pending) > 0 {
+			line = preprocessor.pending[0]
+			preprocessor.pending = preprocessor.pending[1:]
+		} else if text, ok := preprocessor.readLine(); ok {
+			line = text
+		} else if preprocessor.closeInclude() {
+			continue
+		} else {
+			return sourceLine{}, false
+		}
+
+		var code string = line.text[leadingSpaces(line.text):]
+		if code == "" || (code[0] != '.' && len(preprocessor.macros) == 0) { // most lines are neither directives nor macro calls
+			return line, true
+		}
+		var first string = firstField(code)
+		if first == "" {
+			return line, true
+		}
+		switch first {
+		case ".macro":
+			preprocessor.define(line, lineFields(line.text))
+		case ".include":
+			preprocessor.include(line)
+		case ".endm":
+			preprocessor.errorAt(line.file, line.lineNumber, strings.Index(line.text, ".endm")+1, ".endm without .macro")
+		default:
+			if definition, ok := preprocessor.macros[first]; ok {
+				preprocessor.expand(definition, line)
+			} else {
+				return line, true
+			}
+		}
+	}
+}
+
+/* Starts reading the file named by the .include directive on line, whose lines next() hands out
+before the rest of the current file. A file that includes itself, directly or through other files,
+is an error. */
+func (preprocessor *Preprocessor) include(line sourceLine) {
+	var column int = strings.Index(line.text, ".include") + 1
+	if line.macro != nil {
+		preprocessor.errorAt(line.file, line.lineNumber, line.column, ".include inside the body of macro %s (line %d)", line.macro.name, line.bodyLine)
+		return
+	}
+	var path string = strings.TrimSpace(strings.TrimSpace(withoutComment(line.text))[len(".include"):])
+	if len(path) >= 2 && strings.HasPrefix(path, "\"") && strings.HasSuffix(path, "\"") {
+		path = path[1 : len(path)-1]
+	}
+	if path == "" {
+		preprocessor.errorAt(line.file, line.lineNumber, column, "missing file name after .include")
+		return
+	}
+	if filepath.IsAbs(path) == false {
+		path = filepath.Join(filepath.Dir(line.file), path)
+	}
+	if containsString(preprocessor.included, path) == false {
+		preprocessor.included = append(preprocessor.included, path)
+	}
+	for _, open := range preprocessor.files {
+		if filepath.Clean(open.name) == path {
+			preprocessor.errorAt(line.file, line.lineNumber, column, "'%s' includes itself", path)
+			return
+		}
+	}
+	if len(preprocessor.files) > maxIncludeDepth {
+		preprocessor.errorAt(line.file, line.lineNumber, column, "files included more than %d levels deep", maxIncludeDepth)
+		return
+	}
+	source, err1 := ioutil.ReadFile(path)
+	if err1 != nil {
+		var reason error = err1
+		if pathError, ok := err1.(*os.PathError); ok {
+			reason = pathError.Err
+		}
+		preprocessor.errorAt(line.file, line.lineNumber, column, "cannot include '%s': %v", path, reason)
+		return
+	}
+	preprocessor.files = append(preprocessor.files, &inputFile{source: string(source), name: path})
+}
+
+// Reads the definition of a macro, from the .macro directive on line up to the matching .endm
+func (preprocessor *Preprocessor) define(line sourceLine, fields []string) {
+	var column int = strings.Index(line.text, ".macro") + 1
+	if line.macro != nil {
+		preprocessor.errorAt(line.file, line.lineNumber, line.column, ".macro inside the body of macro %s (line %d)", line.macro.name, line.bodyLine)
+		return
+	}
+
+	var definition *macro = &macro{file: line.file, lineNumber: line.lineNumber}
+	var header []string = strings.Fields(strings.Replace(strings.Join(fields[1:], " "), ",", " ", -1))
+	var valid bool = true
+	if len(header) == 0 {
+		preprocessor.errorAt(line.file, line.lineNumber, column, "missing macro name after .macro")
+		valid = false
+	} else {
+		definition.name = header[0]
+		definition.parameters = header[1:]
+		if _, ok := code_comp[definition.name]; ok || isSymbol(definition.name) == false {
+			preprocessor.errorAt(line.file, line.lineNumber, column, "invalid macro name '%s'", definition.name)
+			valid = false
+		} else if previous, ok := preprocessor.macros[definition.name]; ok {
+			preprocessor.errorAt(line.file, line.lineNumber, column, "macro %s already defined at line %d", definition.name, previous.lineNumber)
+			valid = false
+		}
+	}
+
+	for { // the body ends within the file of the .macro directive
+		bodyLine, ok := preprocessor.readLine()
+		if ok == false {
+			break
+		}
+		var text string = bodyLine.text
+		var bodyFields []string = lineFields(text)
+		if len(bodyFields) > 0 && bodyFields[0] == ".endm" {
+			if valid {
+				preprocessor.checkParameters(definition)
+				preprocessor.macros[definition.name] = definition
+			}
+			return
+		}
+		if len(bodyFields) > 0 && bodyFields[0] == ".macro" {
+			preprocessor.errorAt(bodyLine.file, bodyLine.lineNumber, strings.Index(text, ".macro")+1, "nested .macro inside macro %s (line %d)", definition.name, definition.lineNumber)
+			continue
+		}
+		definition.body = append(definition.body, text)
+		definition.bodyLines = append(definition.bodyLines, bodyLine.lineNumber)
+	}
+	preprocessor.errorAt(line.file, line.lineNumber, column, "missing .endm for macro %s", definition.name)
+}
+
+// Reports every \param in the body of the macro that is not one of its parameters
+func (preprocessor *Preprocessor) checkParameters(definition *macro) {
+	for i, text := range definition.body {
+		var code string = withoutComment(text)
+		for j := 0; j < len(code); j++ {
+			if code[j] != '\\' {
+				continue
+			}
+			var name string = symbolPrefix(code[j+1:])
+			if containsString(definition.parameters, name) == false {
+				preprocessor.errorAt(definition.file, definition.bodyLines[i], j+1, "unknown parameter '\\%s' in macro %s", name, definition.name)
+			}
+		}
+	}
+}
+
+// Returns the longest prefix of text that consists of symbol characters
+func symbolPrefix(text string) string {
+	var end int = 0
+	for end < len(text) && isSymbol("_"+text[end:end+1]) {
+		end = end + 1
+	}
+	return text[:end]
+}
+
+func containsString(list []string, value string) bool {
+	for _, element := range list {
+		if element == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Queues the body of the macro, with its arguments and local labels substituted, in place of the call on line
+func (preprocessor *Preprocessor) expand(definition *macro, call sourceLine) {
+	if call.macro == nil {
+		call.column = strings.Index(call.text, definition.name) + 1
+	}
+	if call.depth >= maxMacroDepth {
+		preprocessor.errorAt(call.file, call.lineNumber, call.column, "macro %s nested more than %d levels deep (defined at line %d)", definition.name, maxMacroDepth, definition.lineNumber)
+		return
+	}
+
+	var code string = strings.TrimSpace(withoutComment(call.text))
+	var arguments []string
+	if rest := strings.TrimSpace(code[len(definition.name):]); rest != "" {
+		for _, argument := range strings.Split(rest, ",") {
+			arguments = append(arguments, strings.TrimSpace(argument))
+		}
+	}
+	if len(arguments) != len(definition.parameters) {
+		preprocessor.errorAt(call.file, call.lineNumber, call.column, "macro %s expects %d arguments but is given %d (defined at line %d)", definition.name, len(definition.parameters), len(arguments), definition.lineNumber)
+		return
+	}
+	for i, argument := range arguments {
+		if argument == "" {
+			preprocessor.errorAt(call.file, call.lineNumber, call.column, "empty argument for parameter '%s' of macro %s (defined at line %d)", definition.parameters[i], definition.name, definition.lineNumber)
+			return
+		}
+	}
+
+	preprocessor.expansions = preprocessor.expansions + 1
+	var prefix string = definition.name + "$" + strconv.Itoa(preprocessor.expansions) + "$"
+	var lines []sourceLine
+	for i, text := range definition.body {
+		var expanded string = substitute(text, definition.parameters, arguments, prefix)
+		lines = append(lines, sourceLine{text: expanded, file: call.file, lineNumber: call.lineNumber, column: call.column, macro: definition, bodyLine: definition.bodyLines[i], depth: call.depth + 1})
+	}
+	preprocessor.pending = append(lines, preprocessor.pending...)
+}
+
+// Replaces every \param of text with its argument and every %%label with a label that starts with prefix
+func substitute(text string, parameters []string, arguments []string, prefix string) string {
+	var builder strings.Builder
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\\' {
+			var name string = symbolPrefix(text[i+1:])
+			var index int = -1
+			for j, parameter := range parameters {
+				if parameter == name {
+					index = j
+				}
+			}
+			if index < 0 { // Case: not a parameter, left as it is
+				builder.WriteByte(text[i])
+				continue
+			}
+			builder.WriteString(arguments[index])
+			i = i + len(name)
+		} else if strings.HasPrefix(text[i:], "%%") {
+			var name string = symbolPrefix(text[i+2:])
+			builder.WriteString(prefix + name)
+			i = i + 1 + len(name)
+		} else {
+			builder.WriteByte(text[i])
+		}
+	}
+	return builder.String()
+}
+
+// Returns the error of reading the input, if any; the files that cannot be included are reported as errors in the source
+func (preprocessor *Preprocessor) err() error {
+	return preprocessor.readError
+}
@@ -0,0 +1,54 @@
+package hackasm
+
+import (
+	"strings"
+	"testing"
+)
+
+// A program with macros assembles into the same words as the program with every call written out by hand
+func TestMacroExpansion(t *testing.T) {
+	var tests = []struct {
+		source   string
+		expected string
+	}{
+		{".macro SET var, value\n  @\\value\n  D=A\n  @\\var\n  M=D\n.endm\n  SET x, 5\n  SET y, 7\n", "@5\nD=A\n@x\nM=D\n@7\nD=A\n@y\nM=D\n"},
+		{".macro WAIT\n(%%loop)\n  @%%loop\n  0;JMP\n.endm\n  WAIT\n  WAIT\n", "(A)\n@A\n0;JMP\n(B)\n@B\n0;JMP\n"},
+		{".macro INC r\n  @\\r\n  M=M+1\n.endm\n.macro INC2 r\n  INC \\r\n  INC \\r\n.endm\n  INC2 i\n", "@i\nM=M+1\n@i\nM=M+1\n"},
+		{"// no macros\n@1\n.macro NOP\n.endm\n  NOP\nD=A\n", "@1\nD=A\n"},
+	}
+	for _, test := range tests {
+		words, _ := mustAssemble(t, test.source, Options{FileName: "Macro.asm"})
+		expected, _ := mustAssemble(t, test.expected, Options{FileName: "Expected.asm"})
+		if hackText(t, words) != hackText(t, expected) {
+			t.Errorf("%q:\ngot      %q\nexpected %q", test.source, Disassemble(words, false), Disassemble(expected, false))
+		}
+	}
+}
+
+func TestPreprocessorErrors(t *testing.T) {
+	var tests = []struct {
+		source   string
+		expected string
+	}{
+		{".endm\n", "M.asm:1:1: .endm without .macro"},
+		{".macro\n.endm\n", "M.asm:1:1: missing macro name after .macro"},
+		{".macro 9x\n.endm\n", "M.asm:1:1: invalid macro name '9x'"},
+		{".macro D+1\n.endm\n", "M.asm:1:1: invalid macro name 'D+1'"},
+		{".macro INC\n.endm\n.macro INC\n.endm\n", "M.asm:3:1: macro INC already defined at line 1"},
+		{".macro INC\n@1\n", "M.asm:1:1: missing .endm for macro INC"},
+		{".macro INC a\n  @\\b\n.endm\n", "M.asm:2:4: unknown parameter '\\b' in macro INC"},
+		{".macro INC\n.macro N\n.endm\n", "M.asm:2:1: nested .macro inside macro INC (line 1)"},
+		{".macro INC a, b\n@\\a\n.endm\nINC 1\n", "M.asm:4:1: macro INC expects 2 arguments but is given 1 (defined at line 1)"},
+		{".macro INC a, b\n@\\a\n.endm\n  INC 1,\n", "M.asm:4:3: empty argument for parameter 'b' of macro INC (defined at line 1)"},
+		{".macro INC\nINC\n.endm\nINC\n", "M.asm:4:1: macro INC nested more than 16 levels deep (defined at line 1)"},
+		{".macro INC\n.include \"x.asm\"\n.endm\nINC\n", "M.asm:4:1: .include inside the body of macro INC (line 2)"},
+		{".include\n", "M.asm:1:1: missing file name after .include"},
+		{".include \"nosuch.asm\"\n", "M.asm:1:1: cannot include 'nosuch.asm': no such file or directory"},
+	}
+	for _, test := range tests {
+		_, _, err := Assemble(strings.NewReader(test.source), Options{FileName: "M.asm"})
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("%q: got %v, expected %s", test.source, err, test.expected)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package hackasm
+
+import (
+	"fmt"
+	"strings"
+)
+
+/* General description: "Expands the pseudo-instructions", mnemonics for the idioms that Hack
+programs keep spelling out, which the assembler accepts when Options.Pseudo is set:
+
+	PUSH D              @SP, AM=M+1, A=A-1, M=D
+	POP D               @SP, AM=M-1, D=M
+	GOTO target         @target, 0;JMP
+	IFZERO D target     @target, D;JEQ
+	IFNOTZERO D target  @target, D;JNE
+	SET addr value      @value, D=A, @addr, M=D, or @addr, M=value for the values 0, 1 and -1
+	INC addr            @addr, M=M+1
+	DEC addr            @addr, M=M-1
+	CALL target         @.call$N, D=A, @R15, M=D, @target, 0;JMP, (.call$N)
+	RET                 @R15, A=M, 0;JMP
+
+The operands are separated by spaces or commas, and target, addr and value may be anything that an
+A-instruction loads. CALL leaves the return address in R15 under a local label of its own, so a
+function that calls another has to save R15 first. The parser reads a pseudo-instruction as a
+PSEUDO_COMMAND, which takes no ROM, followed by the instructions it expands into. Strict Hack
+assembly has no pseudo-instructions, so without the option they are reported as not enabled. */
+
+// The operands of a pseudo-instruction and the instructions it expands into, in which $1 and $2 stand for the operands
+type pseudoInstruction struct {
+	operands  string // e.g. "D target", where D stands for the register D itself
+	expansion []string
+}
+
+var pseudo_instructions = map[string]pseudoInstruction{
+	"PUSH":      {"D", []string{"@SP", "AM=M+1", "A=A-1", "M=D"}},
+	"POP":       {"D", []string{"@SP", "AM=M-1", "D=M"}},
+	"GOTO":      {"target", []string{"@$1", "0;JMP"}},
+	"IFZERO":    {"D target", []string{"@$2", "D;JEQ"}},
+	"IFNOTZERO": {"D target", []string{"@$2", "D;JNE"}},
+	"SET":       {"addr value", []string{"@$2", "D=A", "@$1", "M=D"}},
+	"INC":       {"addr", []string{"@$1", "M=M+1"}},
+	"DEC":       {"addr", []string{"@$1", "M=M-1"}},
+	"CALL":      {"target", []string{"@$return", "D=A", "@" + returnRegister, "M=D", "@$1", "0;JMP", "($return)"}},
+	"RET":       {"", []string{"@" + returnRegister, "A=M", "0;JMP"}},
+}
+
+// The register in which CALL leaves the return address for RET
+const returnRegister = "R15"
+
+// Question: "Is command a pseudo-instruction such as PUSH D?"
+func isPseudoInstruction(command string) bool {
+	_, ok := pseudo_instructions[firstField(command)]
+	return ok
+}
+
+// Returns the name and the operands of a pseudo-instruction, e.g. "SET" and ["x", "5"] for SET x, 5
+func pseudoOperands(command string) (string, []string) {
+	var fields []string = strings.Fields(strings.Replace(command, ",", " ", -1))
+	return fields[0], fields[1:]
+}
+
+/* Returns the lines that the pseudo-instruction command of source expands into, which keep the file
+and the line of source, or nil if its operands are wrong. */
+func (parser *Parser) expandPseudo(source sourceLine, command string) []sourceLine {
+	name, operands := pseudoOperands(command)
+	var instruction pseudoInstruction = pseudo_instructions[name]
+	var expected []string = strings.Fields(instruction.operands)
+	var column int = strings.Index(source.text, command) + 1
+	var valid bool = len(operands) == len(expected)
+	for index := 0; valid && index < len(expected); index++ {
+		valid = expected[index] != "D" || operands[index] == "D"
+	}
+	if valid == false {
+		parser.errorAtSource(source, column, "wrong operands for %s, which is written '%s'", name, strings.TrimSpace(name+" "+instruction.operands))
+		return nil
+	}
+
+	var expanded []sourceLine
+	for _, line := range pseudoExpansion(name, operands, parser.calls) {
+		var expandedLine sourceLine = source
+		expandedLine.text = formatIndent + line
+		expandedLine.pseudo = source.text
+		expanded = append(expanded, expandedLine)
+	}
+	if name == "CALL" {
+		parser.calls = parser.calls + 1
+	}
+	return expanded
+}
+
+/* Returns the instructions that the pseudo-instruction name expands into with the given operands,
+where call numbers the label of the return address of a CALL. */
+func pseudoExpansion(name string, operands []string, call int) []string {
+	var lines []string = pseudo_instructions[name].expansion
+	if name == "SET" && len(operands) == 2 && (operands[1] == "0" || operands[1] == "1" || operands[1] == "-1") {
+		lines = []string{"@$1", "M=" + operands[1]} // the ALU computes these values without D
+	}
+	var replacements []string = []string{"$return", fmt.Sprintf(".call$%d", call)}
+	for index, operand := range operands {
+		replacements = append(replacements, fmt.Sprintf("$%d", index+1), operand)
+	}
+	var replacer *strings.Replacer = strings.NewReplacer(replacements...)
+	var expansion []string
+	for _, line := range lines {
+		expansion = append(expansion, replacer.Replace(line))
+	}
+	return expansion
+}
@@ -0,0 +1,52 @@
+package hackasm
+
+import (
+	"strings"
+	"testing"
+)
+
+// Every pseudo-instruction assembles into the same words as the instructions it expands into
+func TestPseudoInstructions(t *testing.T) {
+	var tests = []struct {
+		source   string
+		expected string
+	}{
+		{"PUSH D\n", "@SP\nAM=M+1\nA=A-1\nM=D\n"},
+		{"POP D\n", "@SP\nAM=M-1\nD=M\n"},
+		{"GOTO END\n(END)\n", "@END\n0;JMP\n(END)\n"},
+		{"IFZERO D, 7\n", "@7\nD;JEQ\n"},
+		{"IFNOTZERO D 7\n", "@7\nD;JNE\n"},
+		{"SET x, 5\n", "@5\nD=A\n@x\nM=D\n"},
+		{"SET x, -1\nSET y 0\n", "@x\nM=-1\n@y\nM=0\n"},
+		{"INC R3\nDEC R3\n", "@R3\nM=M+1\n@R3\nM=M-1\n"},
+		{"(MAIN)\n  CALL F\n  CALL F\n(F)\n  RET\n", "(MAIN)\n@A\nD=A\n@R15\nM=D\n@F\n0;JMP\n(A)\n@B\nD=A\n@R15\nM=D\n@F\n0;JMP\n(B)\n(F)\n@R15\nA=M\n0;JMP\n"},
+	}
+	for _, test := range tests {
+		words, _ := mustAssemble(t, test.source, Options{FileName: "Pseudo.asm", Pseudo: true})
+		expected, _ := mustAssemble(t, test.expected, Options{FileName: "Expected.asm"})
+		if hackText(t, words) != hackText(t, expected) {
+			t.Errorf("%q:\ngot      %q\nexpected %q", test.source, Disassemble(words, false), Disassemble(expected, false))
+		}
+	}
+}
+
+func TestPseudoInstructionErrors(t *testing.T) {
+	var tests = []struct {
+		source   string
+		pseudo   bool
+		expected string
+	}{
+		{"PUSH D\n", false, "P.asm:1:1: pseudo-instruction 'PUSH D' is not enabled"},
+		{"  PUSH A\n", true, "P.asm:1:3: wrong operands for PUSH, which is written 'PUSH D'"},
+		{"GOTO\n", true, "P.asm:1:1: wrong operands for GOTO, which is written 'GOTO target'"},
+		{"SET x\n", true, "P.asm:1:1: wrong operands for SET, which is written 'SET addr value'"},
+		{"RET 1\n", true, "P.asm:1:1: wrong operands for RET, which is written 'RET'"},
+		{"IFZERO M, 7\n", true, "P.asm:1:1: wrong operands for IFZERO, which is written 'IFZERO D target'"},
+	}
+	for _, test := range tests {
+		_, _, err := Assemble(strings.NewReader(test.source), Options{FileName: "P.asm", Pseudo: test.pseudo})
+		if err == nil || strings.Split(err.Error(), "\n")[0] != test.expected {
+			t.Errorf("%q: got error %v, expected %q", test.source, err, test.expected)
+		}
+	}
+}
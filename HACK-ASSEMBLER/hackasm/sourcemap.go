@@ -0,0 +1,126 @@
+package hackasm
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+/* General description: "Maps every ROM address of the machine code back to the source line that
+produced it", so a debugger can show where the PC is without parsing the assembly. A source map is
+a JSON object such as
+
+	{"format": "hack-sourcemap/1",
+	 "files": ["Max.asm"],
+	 "labels": [{"name": "OUTPUT_FIRST", "address": 10}, ...],
+	 "words": [
+	  [0, 7, -1],
+	  ...
+	  [0, 17, 0]]}
+
+in which words holds one entry per ROM address: the index of the file in files, the line in that
+file and the index in labels of the nearest label at or before the address, -1 if there is none.
+Lines expanded from a macro map to the line of the macro call, and the files read by .include follow
+the input in files. */
+
+const sourceMapFormat = "hack-sourcemap/1"
+
+// A label of a source map and the ROM address it resolved to
+type SourceMapLabel struct {
+	Name    string `json:"name"`
+	Address int    `json:"address"`
+}
+
+// The source of every word of an assembled program, as written by Assemble() to Options.SourceMap
+type SourceMap struct {
+	Format string           `json:"format"`
+	Files  []string         `json:"files"`
+	Labels []SourceMapLabel `json:"labels"`
+	Words  [][3]int         `json:"words"` // file index, line, label index or -1, per ROM address
+}
+
+// Builds the source map of the words generated by parser
+func newSourceMap(parser *Parser, symboltable *SymbolTable) *SourceMap {
+	var sourceMap *SourceMap = &SourceMap{Format: sourceMapFormat, Files: []string{parser.fileName}, Labels: []SourceMapLabel{}, Words: [][3]int{}}
+	var labelIndexes map[string]int = map[string]int{}
+	var fileIndexes map[string]int = map[string]int{parser.fileName: 0}
+	for romAddress, command := range parser.wordCommands {
+		var source sourceLine = parser.commands[command]
+		fileIndex, ok := fileIndexes[source.file]
+		if ok == false {
+			fileIndex = len(sourceMap.Files)
+			fileIndexes[source.file] = fileIndex
+			sourceMap.Files = append(sourceMap.Files, source.file)
+		}
+		var label string = parser.wordLabels[romAddress]
+		var labelIndex int = -1
+		if label != "" {
+			index, ok := labelIndexes[label]
+			if ok == false {
+				index = len(sourceMap.Labels)
+				labelIndexes[label] = index
+				sourceMap.Labels = append(sourceMap.Labels, SourceMapLabel{Name: label, Address: symboltable.GetAddress(label)})
+			}
+			labelIndex = index
+		}
+		sourceMap.Words = append(sourceMap.Words, [3]int{fileIndex, source.lineNumber, labelIndex})
+	}
+	return sourceMap
+}
+
+/* Writes sourceMap as JSON with one line per word, which keeps the map of a large program small
+enough to read and to diff. */
+func WriteSourceMap(writer io.Writer, sourceMap *SourceMap) error {
+	files, err1 := json.Marshal(sourceMap.Files)
+	if err1 != nil {
+		return err1
+	}
+	labels, err2 := json.Marshal(sourceMap.Labels)
+	if err2 != nil {
+		return err2
+	}
+	bufferedWriter := bufio.NewWriter(writer)
+	fmt.Fprintf(bufferedWriter, "{\"format\": \"%s\",\n \"files\": %s,\n \"labels\": %s,\n \"words\": [", sourceMapFormat, files, labels)
+	for romAddress, word := range sourceMap.Words {
+		if romAddress > 0 {
+			bufferedWriter.WriteString(",")
+		}
+		fmt.Fprintf(bufferedWriter, "\n  [%d, %d, %d]", word[0], word[1], word[2])
+	}
+	bufferedWriter.WriteString("]}\n")
+	return bufferedWriter.Flush()
+}
+
+// Reads a source map written by WriteSourceMap(), checking that its entries refer to its files and labels
+func ReadSourceMap(reader io.Reader, fileName string) (*SourceMap, error) {
+	var sourceMap SourceMap
+	if err1 := json.NewDecoder(reader).Decode(&sourceMap); err1 != nil {
+		return nil, fmt.Errorf("%s: not a source map: %v", fileName, err1)
+	}
+	if sourceMap.Format != sourceMapFormat {
+		return nil, fmt.Errorf("%s: unsupported source map format '%s', expected '%s'", fileName, sourceMap.Format, sourceMapFormat)
+	}
+	for romAddress, word := range sourceMap.Words {
+		if word[0] < 0 || word[0] >= len(sourceMap.Files) {
+			return nil, fmt.Errorf("%s: ROM address %d refers to file %d, but there are %d files", fileName, romAddress, word[0], len(sourceMap.Files))
+		} else if word[2] < -1 || word[2] >= len(sourceMap.Labels) {
+			return nil, fmt.Errorf("%s: ROM address %d refers to label %d, but there are %d labels", fileName, romAddress, word[2], len(sourceMap.Labels))
+		}
+	}
+	return &sourceMap, nil
+}
+
+/* Returns the file and line that produced the word at ROM address pc, along with the nearest label at
+or before it ("" if there is none), and whether pc lies within the program. */
+func (sourceMap *SourceMap) Lookup(pc int) (string, int, string, bool) {
+	if pc < 0 || pc >= len(sourceMap.Words) {
+		return "", 0, "", false
+	}
+	var word [3]int = sourceMap.Words[pc]
+	var label string = ""
+	if word[2] >= 0 {
+		label = sourceMap.Labels[word[2]].Name
+	}
+	return sourceMap.Files[word[0]], word[1], label, true
+}
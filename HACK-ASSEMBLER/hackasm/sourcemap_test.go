@@ -0,0 +1,64 @@
+package hackasm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Every word maps to the line that produced it: a macro call, an included file or the nearest label
+func TestSourceMap(t *testing.T) {
+	var directory string = t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(directory, "Inc.asm"), []byte("// included\n(INNER)\n@INNER\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var source string = ".macro INC r\n  @\\r\n  M=M+1\n.endm\n@0\n(LOOP)\n  INC i\n.include \"Inc.asm\"\n0;JMP\n"
+	var buffer bytes.Buffer
+	var fileName string = filepath.Join(directory, "Main.asm")
+	words, _ := mustAssemble(t, source, Options{FileName: fileName, SourceMap: &buffer})
+	sourceMap, err := ReadSourceMap(&buffer, "Main.map")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{fileName, filepath.Join(directory, "Inc.asm")}; reflect.DeepEqual(sourceMap.Files, expected) == false {
+		t.Errorf("files %q, expected %q", sourceMap.Files, expected)
+	}
+	if expected := []SourceMapLabel{{"LOOP", 1}, {"INNER", 3}}; reflect.DeepEqual(sourceMap.Labels, expected) == false {
+		t.Errorf("labels %v, expected %v", sourceMap.Labels, expected)
+	}
+	var expected = [][3]int{{0, 5, -1}, {0, 7, 0}, {0, 7, 0}, {1, 3, 1}, {0, 9, 1}}
+	if reflect.DeepEqual(sourceMap.Words, expected) == false {
+		t.Errorf("words %v, expected %v", sourceMap.Words, expected)
+	}
+	if len(sourceMap.Words) != len(words) {
+		t.Errorf("%d entries for %d words", len(sourceMap.Words), len(words))
+	}
+	file, line, label, ok := sourceMap.Lookup(3)
+	if file != sourceMap.Files[1] || line != 3 || label != "INNER" || ok == false {
+		t.Errorf("Lookup(3) = %s, %d, %s, %v", file, line, label, ok)
+	}
+	if _, _, _, ok := sourceMap.Lookup(len(words)); ok {
+		t.Errorf("Lookup(%d) is past the end of the program but found", len(words))
+	}
+}
+
+func TestReadSourceMapErrors(t *testing.T) {
+	var tests = []struct {
+		source   string
+		expected string
+	}{
+		{"[]", "M.map: not a source map: "},
+		{`{"format": "hack-sourcemap/2", "files": ["A.asm"], "labels": [], "words": []}`, "M.map: unsupported source map format 'hack-sourcemap/2', expected 'hack-sourcemap/1'"},
+		{`{"format": "hack-sourcemap/1", "files": ["A.asm"], "labels": [], "words": [[0, 1, -1], [1, 2, -1]]}`, "M.map: ROM address 1 refers to file 1, but there are 1 files"},
+		{`{"format": "hack-sourcemap/1", "files": ["A.asm"], "labels": [], "words": [[0, 1, 0]]}`, "M.map: ROM address 0 refers to label 0, but there are 0 labels"},
+	}
+	for _, test := range tests {
+		_, err := ReadSourceMap(strings.NewReader(test.source), "M.map")
+		if err == nil || strings.HasPrefix(err.Error(), test.expected) == false {
+			t.Errorf("%s: got error %v, expected %q", test.source, err, test.expected)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package hackasm
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// General description:  "Keeps a correspondence between symbolic labels and numeric addresses."
+type SymbolTable struct {
+	symbols map[string]int
+	kinds   map[string]int // PREDEFINED_SYMBOL, LABEL_SYMBOL, VARIABLE_SYMBOL or CONSTANT_SYMBOL
+	lines   map[string]int // source line where the symbol was declared or first used
+}
+
+// Kinds of symbols
+const (
+	PREDEFINED_SYMBOL = 0 // one of the 23 predefined symbols
+	LABEL_SYMBOL      = 1 // a ROM address declared by (Xxx)
+	VARIABLE_SYMBOL   = 2 // a RAM address allocated from 16 onwards
+	CONSTANT_SYMBOL   = 3 // a value named by .equ or .define
+)
+
+var symbolKindNames = []string{"predefined", "label", "variable", "constant"}
+
+func InitSymbolTable() *SymbolTable {
+	table := new(SymbolTable)
+	table.symbols = map[string]int{
+		// The Hack language features 23 predefined symbols:
+		"SP":     0,
+		"LCL":    1,
+		"ARG":    2,
+		"THIS":   3,
+		"THAT":   4,
+		"SCREEN": 16384,
+		"KBD":    24576,
+		"R0":     0,
+		"R1":     1,
+		"R2":     2,
+		"R3":     3,
+		"R4":     4,
+		"R5":     5,
+		"R6":     6,
+		"R7":     7,
+		"R8":     8,
+		"R9":     9,
+		"R10":    10,
+		"R11":    11,
+		"R12":    12,
+		"R13":    13,
+		"R14":    14,
+		"R15":    15,
+	}
+	table.kinds = map[string]int{}
+	table.lines = map[string]int{}
+	for symbol := range table.symbols {
+		table.kinds[symbol] = PREDEFINED_SYMBOL
+	}
+	return table
+}
+
+// "Adds the pair (symbol, address) to the SymbolTable", along with the kind of the symbol and the line that declares it
+func (table *SymbolTable) AddEntry(symbol string, address int, kind int, line int) {
+	table.symbols[symbol] = address
+	table.kinds[symbol] = kind
+	table.lines[symbol] = line
+}
+
+// Records the first line that uses the symbol, unless a line is already known for it
+func (table *SymbolTable) markUsed(symbol string, line int) {
+	if table.lines[symbol] == 0 {
+		table.lines[symbol] = line
+	}
+}
+
+// "Does the symbol table contain the given symbol?"
+func (table *SymbolTable) Contains(symbol string) bool {
+	_, ok := table.symbols[symbol]
+	return ok
+}
+
+// "Returns the address associated with the symbols"
+func (table *SymbolTable) GetAddress(symbol string) int {
+	return table.symbols[symbol]
+}
+
+// Returns the kind of the symbol: PREDEFINED_SYMBOL, LABEL_SYMBOL, VARIABLE_SYMBOL or CONSTANT_SYMBOL
+func (table *SymbolTable) Kind(symbol string) int {
+	return table.kinds[symbol]
+}
+
+// Returns the line that declares the symbol or first uses it, or 0 if no line does
+func (table *SymbolTable) Line(symbol string) int {
+	return table.lines[symbol]
+}
+
+// An entry of a .sym file
+type symbolRecord struct {
+	Name    string `json:"name"`
+	Address int    `json:"address"`
+	Kind    string `json:"kind"`
+	Line    int    `json:"line,omitempty"` // 0 for predefined symbols that are never used
+}
+
+// Returns every symbol of the table, ordered by kind, then address, then name
+func (table *SymbolTable) records() []symbolRecord {
+	var records []symbolRecord
+	for symbol, address := range table.symbols {
+		records = append(records, symbolRecord{Name: symbol, Address: address, Kind: symbolKindNames[table.kinds[symbol]], Line: table.lines[symbol]})
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Kind != records[j].Kind {
+			return table.kinds[records[i].Name] < table.kinds[records[j].Name]
+		} else if records[i].Address != records[j].Address {
+			return records[i].Address < records[j].Address
+		}
+		return records[i].Name < records[j].Name
+	})
+	return records
+}
+
+/* Writes the symbol table in the given format:
+"json": {"file": "Max.asm", "symbols": [{"name": "R0", "address": 0, "kind": "predefined", "line": 8}, ...]}
+"text": one "name address kind line" entry per line, with "-" as the line of unused predefined symbols */
+func WriteSymbols(writer io.Writer, symboltable *SymbolTable, format string, fileName string) error {
+	var records []symbolRecord = symboltable.records()
+	switch format {
+	case "json":
+		type symbolFile struct {
+			File    string         `json:"file"`
+			Symbols []symbolRecord `json:"symbols"`
+		}
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(symbolFile{File: fileName, Symbols: records})
+	case "text":
+		bufferedWriter := bufio.NewWriter(writer)
+		fmt.Fprintf(bufferedWriter, "# %s: name address kind line\n", fileName)
+		for _, record := range records {
+			var line string = "-"
+			if record.Line != 0 {
+				line = strconv.Itoa(record.Line)
+			}
+			fmt.Fprintf(bufferedWriter, "%s %d %s %s\n", record.Name, record.Address, record.Kind, line)
+		}
+		return bufferedWriter.Flush()
+	default:
+		return fmt.Errorf("unknown symbol file format '%s'", format)
+	}
+}
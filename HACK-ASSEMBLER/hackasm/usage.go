@@ -0,0 +1,119 @@
+package hackasm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/* General description: "Reports how much of the ROM and the RAM a program uses." The Hack ROM
+holds 32768 words, and the variables are allocated from RAM[16] upwards, where they must stay below
+the stack of the VM at RAM[256] and the screen at RAM[16384]. The regions of a program are the
+parts of its code from one label to the next, so the largest ones show where the words go. */
+
+const romSize = 32768
+
+const stackBase = 256
+
+const screenBase = 16384
+
+// The code from a label up to the next label, or up to the end of the program
+type Region struct {
+	Label string // "" for the code before the first label
+	Start int    // the ROM address of the label
+	Words int
+}
+
+// The ROM and RAM used by a program, as written by Assemble() to Options.Usage
+type UsageReport struct {
+	ROMWords        int
+	Variables       int      // the number of variables allocated from RAM[16] upwards
+	HighestVariable int      // the highest RAM address of a variable, 15 if there are none
+	Regions         []Region // the regions of the code, the largest first
+}
+
+// Returns the usage of the program that consists of words, whose labels and variables are in symboltable
+func newUsageReport(words []uint16, symboltable *SymbolTable) UsageReport {
+	var report UsageReport = UsageReport{ROMWords: len(words), HighestVariable: 15}
+	var starts map[int]string = map[int]string{} // the label that names each region, the first one declared at its address
+	for symbol, kind := range symboltable.kinds {
+		var address int = symboltable.GetAddress(symbol)
+		if kind == VARIABLE_SYMBOL {
+			report.Variables = report.Variables + 1
+			if address > report.HighestVariable {
+				report.HighestVariable = address
+			}
+		} else if kind == LABEL_SYMBOL && address < len(words) {
+			if other, ok := starts[address]; ok == false || symboltable.lines[symbol] < symboltable.lines[other] || symboltable.lines[symbol] == symboltable.lines[other] && symbol < other {
+				starts[address] = symbol
+			}
+		}
+	}
+	if _, ok := starts[0]; ok == false && len(words) > 0 {
+		starts[0] = ""
+	}
+
+	var addresses []int
+	for address := range starts {
+		addresses = append(addresses, address)
+	}
+	sort.Ints(addresses)
+	for index, address := range addresses {
+		var end int = len(words)
+		if index+1 < len(addresses) {
+			end = addresses[index+1]
+		}
+		report.Regions = append(report.Regions, Region{Label: starts[address], Start: address, Words: end - address})
+	}
+	sort.SliceStable(report.Regions, func(i, j int) bool {
+		return report.Regions[i].Words > report.Regions[j].Words
+	})
+	return report
+}
+
+/* Returns the report in a few lines, such as
+	ROM: 27483 of 32768 words (83.9%), 5285 free
+	RAM: 4 variables at RAM[16..19], 236 words below the stack at RAM[256]
+	largest regions: Math.divide 520 words at ROM[1830], ...
+showing up to regions regions. */
+func (report UsageReport) Format(regions int) string {
+	var builder strings.Builder
+	if report.ROMWords > romSize {
+		fmt.Fprintf(&builder, "ROM: %d of %d words (%.1f%%), %d over\n", report.ROMWords, romSize, 100*float64(report.ROMWords)/romSize, report.ROMWords-romSize)
+	} else {
+		fmt.Fprintf(&builder, "ROM: %d of %d words (%.1f%%), %d free\n", report.ROMWords, romSize, 100*float64(report.ROMWords)/romSize, romSize-report.ROMWords)
+	}
+	var variables string = fmt.Sprintf("%d variables at RAM[16..%d]", report.Variables, report.HighestVariable)
+	if report.Variables == 1 {
+		variables = "1 variable at RAM[16]"
+	}
+	switch {
+	case report.Variables == 0:
+		builder.WriteString("RAM: no variables\n")
+	case report.HighestVariable >= screenBase:
+		fmt.Fprintf(&builder, "RAM: %s, which run into the screen at RAM[%d]\n", variables, screenBase)
+	case report.HighestVariable >= stackBase:
+		fmt.Fprintf(&builder, "RAM: %s, which run into the stack at RAM[%d]\n", variables, stackBase)
+	default:
+		fmt.Fprintf(&builder, "RAM: %s, %d words below the stack at RAM[%d]\n", variables, stackBase-1-report.HighestVariable, stackBase)
+	}
+	var shown []string
+	for index, region := range report.Regions {
+		if index == regions {
+			break
+		}
+		var label string = region.Label
+		if label == "" {
+			label = "(before the first label)"
+		}
+		shown = append(shown, fmt.Sprintf("%s %d words at ROM[%d]", label, region.Words, region.Start))
+	}
+	if len(shown) > 0 {
+		builder.WriteString("largest regions: " + strings.Join(shown, ", ") + "\n")
+	}
+	return builder.String()
+}
+
+func (report UsageReport) String() string {
+	return report.Format(5)
+}
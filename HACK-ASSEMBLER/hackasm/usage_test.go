@@ -0,0 +1,25 @@
+package hackasm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUsageReport(t *testing.T) {
+	var usage UsageReport
+	mustAssemble(t, "@i\nM=0\n(LOOP)\n@i\nM=M+1\n@j\nM=D\n@LOOP\n0;JMP\n", Options{FileName: "Count.asm", Usage: &usage})
+	var expected string = "ROM: 8 of 32768 words (0.0%), 32760 free\n" +
+		"RAM: 2 variables at RAM[16..17], 238 words below the stack at RAM[256]\n" +
+		"largest regions: LOOP 6 words at ROM[2], (before the first label) 2 words at ROM[0]\n"
+	if usage.String() != expected {
+		t.Errorf("got\n%s\nexpected\n%s", usage.String(), expected)
+	}
+}
+
+// A report of more words than the ROM holds tells how many are over instead of a negative number of free words
+func TestUsageReportOverROM(t *testing.T) {
+	var usage UsageReport = UsageReport{ROMWords: 32772, HighestVariable: 15}
+	if first := strings.SplitN(usage.String(), "\n", 2)[0]; first != "ROM: 32772 of 32768 words (100.0%), 4 over" {
+		t.Errorf("got %q", first)
+	}
+}
@@ -1,319 +1,241 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
+	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
-)
+	"time"
 
-// General description: "Translates Hack assembly language mnemonics into binary codes."
-var code_dest = map[string]string{
-	"":    "000",
-	"M":   "001",
-	"D":   "010",
-	"MD":  "011",
-	"A":   "100",
-	"AM":  "101",
-	"AD":  "110",
-	"AMD": "111",
-}
-
-var code_comp = map[string]string{
-	"0":   "0101010",
-	"1":   "0111111",
-	"-1":  "0111010",
-	"D":   "0001100",
-	"A":   "0110000",
-	"M":   "1110000",
-	"!D":  "0001101",
-	"!A":  "0110001",
-	"!M":  "1110001",
-	"-D":  "0001111",
-	"-A":  "0110011",
-	"-M":  "1110011",
-	"D+1": "0011111",
-	"A+1": "0110111",
-	"M+1": "1110111",
-	"D-1": "0001110",
-	"A-1": "0110010",
-	"M-1": "1110010",
-	"D+A": "0000010",
-	"D+M": "1000010",
-	"D-A": "0010011",
-	"D-M": "1010011",
-	"A-D": "0000111",
-	"M-D": "1000111",
-	"D&A": "0000000",
-	"D&M": "1000000",
-	"D|A": "0010101",
-	"D|M": "1010101",
-}
-
-var code_jump = map[string]string{
-	"":    "000",
-	"JGT": "001",
-	"JEQ": "010",
-	"JGE": "011",
-	"JLT": "100",
-	"JNE": "101",
-	"JLE": "110",
-	"JMP": "111",
-}
-
-// Returns the binary code of the dest mnemonic
-func dest(mnemonic string) string {
-	return code_dest[mnemonic]
-}
-
-// Returns the binary code of the comp mnemonic
-func comp(mnemonic string) string {
-	return code_comp[mnemonic]
-}
-
-// Returns the binary code of the jump mnemonic
-func jump(mnemonic string) string {
-	return code_jump[mnemonic]
-}
-
-// General description:  "Keeps a correspondence between symbolic labels and numeric addresses."
-type SymbolTable struct {
-	symbols map[string]int
-}
-
-func initSymbolTable() *SymbolTable {
-	table := new(SymbolTable)
-	table.symbols = map[string]int{
-		// The Hack language features 23 predefined symbols:
-		"SP":     0,
-		"LCL":    1,
-		"ARG":    2,
-		"THIS":   3,
-		"THAT":   4,
-		"SCREEN": 16384,
-		"KBD":    24576,
-		"R0":     0,
-		"R1":     1,
-		"R2":     2,
-		"R3":     3,
-		"R4":     4,
-		"R5":     5,
-		"R6":     6,
-		"R7":     7,
-		"R8":     8,
-		"R9":     9,
-		"R10":    10,
-		"R11":    11,
-		"R12":    12,
-		"R13":    13,
-		"R14":    14,
-		"R15":    15,
-	}
-	return table
-}
-
-// "Adds the pair (symbol, address) to the SymbolTable
-func (table *SymbolTable) addEntry(symbol string, address int) {
-	table.symbols[symbol] = address
-}
-
-// "Does the symbol table contain the given symbol?"
-func (table *SymbolTable) contains(symbol string) bool {
-	_, ok := table.symbols[symbol]
-	return ok
-}
-
-// "Returns the address associated with the symbols"
-func (table *SymbolTable) GetAddress(symbol string) int {
-	return table.symbols[symbol]
-}
-
-/* General description: "Encapsulates access to the input code.
-Reads an assembly language command, parses it, and provides convenient access to command's components (fields and symbols).
-Removes all white space and comments" */
-const (
-	A_COMMAND = 0
-	C_COMMAND = 1
-	L_COMMAND = 2
+	"github.com/Pingumaniac/NAND2TETRIS-IN-GO/HACK-ASSEMBLER/hackasm"
 )
 
-type Parser struct {
-	file           *os.File
-	scanner        *bufio.Scanner
-	currentCommand string
-	ramAddress     int
-}
+// Disassembles the .hack file at inputPath ("-" for stdin) into outputPath ("-" for stdout)
+func disassembleFile(inputPath string, outputPath string, vmNames bool) error {
+	var input io.Reader = os.Stdin
+	var fileName string = "<stdin>"
+	if inputPath != "-" {
+		file, err1 := os.Open(inputPath)
+		if err1 != nil {
+			return err1
+		}
+		defer file.Close()
+		input = file
+		fileName = inputPath
+	}
 
-func initParser(file *os.File) *Parser {
-	scanner := bufio.NewScanner(file)
-	var parser Parser = Parser{file: file, scanner: scanner, ramAddress: 0}
-	return &parser
+	words, err2 := hackasm.ReadHack(input, fileName)
+	if err2 != nil {
+		return err2
+	}
+	var assembly string = "// Disassembled from " + filepath.Base(fileName) + "\n" + hackasm.Disassemble(words, vmNames)
+	if outputPath == "-" {
+		_, err3 := io.WriteString(os.Stdout, assembly)
+		return err3
+	}
+	return ioutil.WriteFile(outputPath, []byte(assembly), 0644)
 }
 
-// Question: "Are there more commands in the input?"
-func (parser *Parser) hasMoreCommands() bool {
-	for parser.scanner.Scan() {
-		line := parser.scanner.Text()
+// The files written for one assembled input. An empty path means that the file is not wanted.
+type outputFiles struct {
+	hack         string          // the machine code, "-" for the standard output
+	format       string          // the output format of the machine code, "hack" by default
+	listing      string          // the .lst listing
+	symbols      string          // the .sym symbol file
+	symbolFormat string          // "json" or "text"
+	sourceMap    string          // the .map source map
+	options      hackasm.Options // the predefined symbols, the optimizer, the instruction set and the limits
+	object       bool            // write a relocatable object file for the linker instead of machine code
+	usage        bool            // report the ROM and RAM used by the program
+}
 
-		if strings.HasPrefix(line, "//") { // Case: this line is a comment
-			continue
-		} else if strings.TrimSpace(line) == "" { // Case: this line is empty
-			continue
-		} else {
-			return true
+/* Assembles the .asm file at inputPath into the files named by outputs.
+"-" stands for the standard input and the standard output respectively.
+Every file gets its own symbol table, so labels and variables never leak from one file into the next.
+Nothing is written unless the whole file has been translated without errors. Returns the number of
+instructions, and writes the reports of the optimizer and of the ROM and RAM usage to log. */
+func assembleFile(inputPath string, outputs outputFiles, log io.Writer) (int, error) {
+	var input io.Reader = os.Stdin
+	var fileName string = "<stdin>"
+	if inputPath != "-" {
+		file, err1 := os.Open(inputPath)
+		if err1 != nil {
+			return 0, err1
 		}
+		defer file.Close()
+		input = file
+		fileName = inputPath
 	}
-	return false
-}
 
-/* "Reads the next command from the input and makes it the current command.
-Should be called only if hasMoreCommands() is true.
-Initially there is no curent command."
-*/
-func (parser *Parser) advance() {
-	var inputCommand string = parser.scanner.Text() // Read next command
-	var actualCommand string = strings.Split(inputCommand, "//")[0]
-	parser.currentCommand = strings.TrimSpace(actualCommand)
-}
-
-/* "Returns the type of the current command:
-A_COMMAND for @Xxx whjere Xxx is either a symbol or a decimal number
-C_COMMAND for dest=comp;jump
-L_COMMAND (actually, pseudo-command) for (Xxx) where Xxx is a symbol"
-*/
-func (parser *Parser) commandType() int {
-	if strings.HasPrefix(parser.currentCommand, "@") {
-		return A_COMMAND
-	} else if strings.HasPrefix(parser.currentCommand, "(") && strings.HasSuffix(parser.currentCommand, ")") {
-		return L_COMMAND
+	var listing *bytes.Buffer
+	if outputs.listing != "" {
+		listing = new(bytes.Buffer)
+	}
+	var sourceMap *bytes.Buffer
+	if outputs.sourceMap != "" {
+		sourceMap = new(bytes.Buffer)
+	}
+	var report hackasm.OptimizationReport
+	var usage hackasm.UsageReport
+	var options hackasm.Options = outputs.options
+	options.FileName = fileName
+	options.Listing = writerOrNil(listing)
+	options.SourceMap = writerOrNil(sourceMap)
+	options.Optimizations = &report
+	if outputs.usage {
+		options.Usage = &usage
+	}
+	var format string = outputs.format
+	if format == "" {
+		format = "hack"
+	}
+	var hack bytes.Buffer
+	var symboltable *hackasm.SymbolTable
+	var instructions int
+	var err2 error
+	if outputs.object {
+		var object *hackasm.Object
+		object, symboltable, err2 = hackasm.AssembleObject(input, options)
+		if err2 == nil {
+			instructions = len(object.Code)
+			err2 = hackasm.WriteObject(&hack, object)
+		}
 	} else {
-		return C_COMMAND
+		var words []uint16
+		words, symboltable, err2 = hackasm.Assemble(input, options)
+		if err2 == nil {
+			instructions = len(words)
+			err2 = hackasm.EncodeWords(&hack, words, format, fileName)
+		}
 	}
-}
-
-/* "Returns the symbol or decimal Xxx of the current command @Xxx or (Xxx).
-Should be called only when commandType() is A_COMMAND or L_COMMAND."
-*/
-func (parser *Parser) symbol() string {
-	var commandtype int = parser.commandType()
-	if commandtype == A_COMMAND {
-		var val string = strings.Replace(parser.currentCommand, "@", "", 1)
-		return val
-	} else if commandtype == L_COMMAND {
-		var val string = strings.Replace(parser.currentCommand, "(", "", 1)
-		val = strings.Replace(val, ")", "", 1)
-		return val
-	} else { // C_COMMAND does not consist of a symbol
-		return parser.currentCommand
+	if errorList, ok := err2.(hackasm.ErrorList); ok {
+		return 0, errorList
+	} else if err2 != nil {
+		return 0, fmt.Errorf("%s: %v", inputPath, err2)
 	}
-
+	if options.Optimize {
+		fmt.Fprintf(log, "%s: %v\n", fileName, report)
+	}
+	if outputs.usage {
+		fmt.Fprintf(log, "%s:\n  %s", fileName, strings.Replace(strings.TrimSuffix(usage.String(), "\n"), "\n", "\n  ", -1)+"\n")
+	}
+	if sourceMap != nil {
+		if err3 := ioutil.WriteFile(outputs.sourceMap, sourceMap.Bytes(), 0644); err3 != nil {
+			return 0, err3
+		}
+	}
+	return instructions, writeOutputs(outputs, hack.Bytes(), listing, symboltable, fileName)
 }
 
-/* "Returns the dest mnemonic in the current C-command (8 possibilities)
-Should be called only when commandType is C_COMMAND."
-*/
-func (parser *Parser) dest() string {
-	if strings.Contains(parser.currentCommand, "=") {
-		return strings.Split(parser.currentCommand, "=")[0]
-	} else {
-		return ""
+// Writes the machine code or object file, the listing unless it is nil, and the symbol file named by outputs
+func writeOutputs(outputs outputFiles, hack []byte, listing *bytes.Buffer, symboltable *hackasm.SymbolTable, fileName string) error {
+	if listing != nil {
+		if err1 := ioutil.WriteFile(outputs.listing, listing.Bytes(), 0644); err1 != nil {
+			return err1
+		}
+	}
+	if outputs.symbols != "" {
+		var symbols bytes.Buffer
+		if err2 := hackasm.WriteSymbols(&symbols, symboltable, outputs.symbolFormat, filepath.Base(fileName)); err2 != nil {
+			return err2
+		}
+		if err3 := ioutil.WriteFile(outputs.symbols, symbols.Bytes(), 0644); err3 != nil {
+			return err3
+		}
+	}
+	if outputs.hack == "-" {
+		_, err4 := os.Stdout.Write(hack)
+		return err4
 	}
+	return ioutil.WriteFile(outputs.hack, hack, 0644)
 }
 
-/* "Returns the comp mnemonic in the current C-command (28 possibilities).
-Should be called only when commandType() is C_COMMAND."
-*/
-func (parser *Parser) comp() string {
-	if strings.Contains(parser.currentCommand, "=") {
-		return strings.Split(parser.currentCommand, "=")[1]
-	} else {
-		return strings.Split(parser.currentCommand, ";")[0]
+// Returns buffer as an io.Writer, or a nil io.Writer if buffer is nil
+func writerOrNil(buffer *bytes.Buffer) io.Writer {
+	if buffer == nil {
+		return nil
 	}
+	return buffer
 }
 
-/* "Returns the jump mnemonic in the current C-Command (8 possiblities).
-Should be called only when commandType() is C_COMMAND."
-*/
-func (parser *Parser) jump() string {
-	if strings.Contains(parser.currentCommand, ";") {
-		return strings.Split(parser.currentCommand, ";")[1]
-	} else {
-		return ""
+/* Returns the path of a file that accompanies the machine code at outputPath, with the extension ext.
+When the machine code goes to the standard output, the file is placed next to the input file instead. */
+func companionPath(inputPath string, outputPath string, ext string) (string, error) {
+	if outputPath != "-" {
+		return strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ext, nil
+	} else if inputPath != "-" {
+		return strings.TrimSuffix(inputPath, filepath.Ext(inputPath)) + ext, nil
 	}
+	return "", fmt.Errorf("cannot name the %s file of the standard input; use -o", ext)
 }
 
-func decimalToBinary(decimal int) int {
-	var binary int = 0
-	var counter int = 1
-	var remainder int = 0
-
-	for decimal != 0 {
-		remainder = decimal % 2
-		decimal = decimal / 2
-		binary = binary + remainder*counter
-		counter = counter * 10
+// Returns the path of the output file with the extension ext for inputPath, placed in outputDir unless outputDir is empty
+func outputPathFor(inputPath string, outputDir string, ext string) string {
+	if inputPath == "-" {
+		return "-"
 	}
-	return binary
+	var outputPath string = strings.TrimSuffix(inputPath, ".asm") + ext
+	if outputDir != "" {
+		outputPath = filepath.Join(outputDir, filepath.Base(outputPath))
+	}
+	return outputPath
 }
 
-func addLCOMMAND(parser *Parser, symboltable *SymbolTable) *SymbolTable {
-	for parser.hasMoreCommands() {
-		parser.advance()
-		if parser.commandType() == L_COMMAND {
-			symboltable.addEntry(parser.symbol(), parser.ramAddress)
-		} else {
-			parser.ramAddress = parser.ramAddress + 1
+// Expands every directory among paths into the files with the extension ext it contains, and every glob pattern into the paths it matches
+func expandInputs(patterns []string, ext string) ([]string, error) {
+	var paths []string
+	for _, pattern := range patterns {
+		if pattern == "-" || strings.ContainsAny(pattern, "*?[") == false {
+			paths = append(paths, pattern)
+			continue
+		}
+		matches, err1 := filepath.Glob(pattern)
+		if err1 != nil {
+			return nil, fmt.Errorf("%s: %v", pattern, err1)
+		} else if len(matches) == 0 {
+			return nil, fmt.Errorf("%s: no files match", pattern)
 		}
+		paths = append(paths, matches...)
 	}
-	return symboltable
-}
 
-func generateHack(filepath string, parser *Parser, symboltable *SymbolTable) {
-	output, err3 := os.Create(strings.TrimSuffix(filepath, ".asm") + ".hack")
-	if err3 != nil {
-		fmt.Println(err3)
-	}
-	writer := bufio.NewWriter(output)
-	defer writer.Flush()
-
-	_, err4 := parser.file.Seek(0, 0)
-	if err4 != nil {
-		fmt.Println(err4)
-	}
-	parser.scanner = bufio.NewScanner(parser.file)
-	parser.currentCommand = ""
-	parser.ramAddress = 16
-
-	var hackCommand string
-	for parser.hasMoreCommands() {
-		parser.advance()
-		if parser.commandType() == A_COMMAND {
-			symbol := parser.symbol()
-			address, err5 := strconv.Atoi(symbol)
-			if err5 != nil {
-				if symboltable.contains(symbol) == false {
-					symboltable.addEntry(symbol, parser.ramAddress)
-					address = parser.ramAddress
-					parser.ramAddress = parser.ramAddress + 1
-				} else {
-					address = symboltable.GetAddress(symbol)
-				}
-			}
+	var inputs []string
+	for _, path := range paths {
+		if path == "-" {
+			inputs = append(inputs, path)
+			continue
+		}
+		info, err1 := os.Stat(path)
+		if err1 != nil {
+			return nil, err1
+		}
+		if info.IsDir() == false {
+			inputs = append(inputs, path)
+			continue
+		}
 
-			hackCommand = strconv.Itoa(decimalToBinary(address)) // convert address from decimal to binary
-			for len(hackCommand) < 16 {
-				hackCommand = "0" + hackCommand
+		files, err2 := ioutil.ReadDir(path) // os.ReadDir not supported in Coursera
+		if err2 != nil {
+			return nil, err2
+		}
+		var found bool = false
+		for _, file := range files {
+			if file.IsDir() == false && filepath.Ext(file.Name()) == ext {
+				inputs = append(inputs, filepath.Join(path, file.Name()))
+				found = true
 			}
-			hackCommand = hackCommand + "\n"
-			writer.WriteString(hackCommand)
 		}
-		if parser.commandType() == C_COMMAND {
-			hackCommand = "111" + comp(parser.comp()) + dest(parser.dest()) + jump(parser.jump()) + "\n"
-			writer.WriteString(hackCommand)
+		if found == false {
+			return nil, fmt.Errorf("%s: no %s files found", path, ext)
 		}
 	}
-	fmt.Println(strings.TrimSuffix(filepath, ".asm") + ".hack successfully created.")
+	return inputs, nil
 }
 
 func getChoice() bool {
@@ -336,9 +258,9 @@ func getChoice() bool {
 	}
 }
 
-func main() {
-	var symboltable *SymbolTable = initSymbolTable()
-
+// Prompts for one .asm file after another. Returns 1 if any of them failed to assemble.
+func runInteractive() int {
+	var status int = 0
 	for {
 		fmt.Println("Enter the path of the .asm file to compile into .hack file:")
 		fmt.Print(">")
@@ -346,17 +268,17 @@ func main() {
 		_, err1 := fmt.Scanln(&filepath)
 		if err1 != nil {
 			fmt.Println(err1)
+			return 1
 		}
 
-		file, err2 := os.Open(filepath)
+		var outputPath string = outputPathFor(filepath, "", ".hack")
+		_, err2 := assembleFile(filepath, outputFiles{hack: outputPath}, os.Stderr)
 		if err2 != nil {
 			fmt.Println(err2)
+			status = 1
+		} else if outputPath != "-" {
+			fmt.Println(outputPath + " successfully created.")
 		}
-		defer file.Close()
-
-		var parser *Parser = initParser(file)
-		symboltable = addLCOMMAND(parser, symboltable)
-		generateHack(filepath, parser, symboltable)
 		var choice bool = getChoice()
 		if choice {
 			continue
@@ -364,4 +286,959 @@ func main() {
 			break
 		}
 	}
+	return status
+}
+
+// Returns the built-in instruction set of the given name, or else the one in the JSON file at that path
+func loadInstructionSet(nameOrPath string) (*hackasm.InstructionSet, error) {
+	if instructionset, ok := hackasm.BuiltinInstructionSet(nameOrPath); ok {
+		return instructionset, nil
+	}
+	file, err1 := os.Open(nameOrPath)
+	if err1 != nil {
+		return nil, fmt.Errorf("unknown instruction set '%s'; expected one of %s or a JSON file", nameOrPath, strings.Join(hackasm.InstructionSetNames(), ", "))
+	}
+	defer file.Close()
+	return hackasm.LoadInstructionSet(file, nameOrPath)
+}
+
+// The symbols predefined by repeated -D NAME=value flags
+type symbolDefinitions map[string]int
+
+func (definitions symbolDefinitions) String() string {
+	var pairs []string
+	for name, value := range definitions {
+		pairs = append(pairs, name+"="+strconv.Itoa(value))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (definitions symbolDefinitions) Set(text string) error {
+	var equals int = strings.Index(text, "=")
+	if equals <= 0 {
+		return fmt.Errorf("expected NAME=value, got '%s'", text)
+	}
+	value, err1 := strconv.ParseInt(text[equals+1:], 0, 32) // base 0 accepts 0x and 0b like the assembler
+	if err1 != nil {
+		return fmt.Errorf("invalid value in '%s'", text)
+	}
+	definitions[text[:equals]] = int(value)
+	return nil
+}
+
+const usage = `Usage: hackassembler [-o output] [-f format] [-c] [-O] [-j n] [-q] [-lst] [-sym json|text] [-map] [-usage] [-max-rom words] [-max-var address] [-D NAME=value] [-isa name|table.json] [-aliases] [-pseudo] [-watch [-interval duration] [-run command]] file.asm|directory|- ...
+       hackassembler lint [-disable rule,...] [-rules] [-D NAME=value] [-isa name|table.json] [-aliases] [-pseudo] file.asm|directory|- ...
+       hackassembler fmt [-w] [-check] [-renumber] [-D NAME=value] [-isa name|table.json] [-aliases] [-pseudo] file.asm|directory|- ...
+       hackassembler link [-o output] [-f format] [-q] [-sym json|text] file.obj|directory ...
+       hackassembler disasm [-o output] [-vm] file.hack|directory|- ...
+       hackassembler compare [-map first.map] first.hack second.hack
+       hackassembler bench [-f format] [-O] [-limit duration] [file.asm|directory ...]
+
+Translates each Hack assembly file into a .hack file next to it, or into the
+output format given by -f. Directories are expanded into the .asm files they
+contain, patterns such as "projects/*/*.asm" into the files they match, and "-" reads from the standard input and writes to the standard output. Without arguments, the assembler prompts for
+the files to translate one at a time. Several files are assembled in parallel, and
+the results are reported in the order of the arguments. With -watch, the files
+are assembled again whenever they or the files they include change. An existing
+file or directory named like a subcommand, such as lint, is assembled instead, and
+so is every argument after --, e.g. hackassembler -- lint.
+
+Flags:
+`
+
+// Assembles the files named on the command line. Returns the exit status of the program.
+func runBatch(args []string) int {
+	flags := flag.NewFlagSet("hackassembler", flag.ContinueOnError)
+	var output *string = flags.String("o", "", "output `path`: a file (or - for stdout) for a single input, a directory for several")
+	var format *string = flags.String("f", "hack", "output `format`: "+strings.Join(hackasm.OutputFormatNames(), ", "))
+	var quiet *bool = flags.Bool("q", false, "do not report the files that were created")
+	var listing *bool = flags.Bool("lst", false, "also write a .lst listing next to each .hack file")
+	var symbols *string = flags.String("sym", "", "also write a .sym symbol file next to each .hack file, in `format` json or text")
+	var sourceMap *bool = flags.Bool("map", false, "also write a .map source map from ROM addresses to source lines next to each .hack file")
+	var object *bool = flags.Bool("c", false, "write a relocatable .obj object file for the link command instead of machine code")
+	var workers *int = flags.Int("j", runtime.NumCPU(), "assemble up to `n` files at the same time")
+	var optimize *bool = flags.Bool("O", false, "remove redundant instructions and report the instructions saved per optimization")
+	var predefined symbolDefinitions = symbolDefinitions{}
+	flags.Var(predefined, "D", "predefine a symbol as `NAME=value`, e.g. -D LED=0x6001; may be repeated")
+	var isa *string = flags.String("isa", "hack", "instruction set: "+strings.Join(hackasm.InstructionSetNames(), ", ")+", or the `path` of a JSON table")
+	var aliases *bool = flags.Bool("aliases", false, "accept operands and destinations in another order, such as M+D for D+M or DM for MD")
+	var pseudo *bool = flags.Bool("pseudo", false, "expand pseudo-instructions such as PUSH D, GOTO label and SET addr value")
+	var reportUsage *bool = flags.Bool("usage", false, "report the ROM words, the RAM variables and the largest label-delimited regions of each file")
+	var maxROM *int = flags.Int("max-rom", 0, "fail if the machine code takes more than `words` words, e.g. 32768; 0 for no limit")
+	var maxVariable *int = flags.Int("max-var", 0, "fail if a variable lands above RAM `address`, e.g. 255 to stay below the stack; 0 for no limit")
+	var watch *bool = flags.Bool("watch", false, "keep running and reassemble the files whenever they or the files they include change")
+	var interval *time.Duration = flags.Duration("interval", 500*time.Millisecond, "with -watch, how often to look for changes")
+	var command *string = flags.String("run", "", "with -watch, run the shell `command` after every build in which all files assemble, e.g. \"./test.sh\"")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	if err1 := flags.Parse(args); err1 == flag.ErrHelp {
+		return 0
+	} else if err1 != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	if *symbols != "" && *symbols != "json" && *symbols != "text" {
+		fmt.Fprintf(os.Stderr, "unknown symbol file format '%s'\n", *symbols)
+		return 2
+	}
+	ext, ok := hackasm.FormatExtension(*format)
+	if ok == false {
+		fmt.Fprintf(os.Stderr, "unknown output format '%s'; expected one of %s\n", *format, strings.Join(hackasm.OutputFormatNames(), ", "))
+		return 2
+	}
+	if *object {
+		ext = ".obj"
+	}
+	if *workers < 1 {
+		fmt.Fprintln(os.Stderr, "-j needs at least one worker")
+		return 2
+	}
+	if *object && *sourceMap {
+		fmt.Fprintln(os.Stderr, "-map needs machine code; the linker decides the ROM addresses of an object file")
+		return 2
+	} else if *object && (*reportUsage || *maxROM != 0 || *maxVariable != 0) {
+		fmt.Fprintln(os.Stderr, "-usage, -max-rom and -max-var need machine code; the linker places the code and the variables of an object file")
+		return 2
+	} else if *maxROM < 0 || *maxVariable < 0 {
+		fmt.Fprintln(os.Stderr, "-max-rom and -max-var cannot be negative")
+		return 2
+	}
+	instructionset, err1 := loadInstructionSet(*isa)
+	if err1 != nil {
+		fmt.Fprintln(os.Stderr, err1)
+		return 2
+	}
+	var options hackasm.Options = hackasm.Options{Predefined: predefined, Optimize: *optimize, Aliases: *aliases, Pseudo: *pseudo, InstructionSet: instructionset, MaxROMWords: *maxROM, MaxVariableAddress: *maxVariable}
+
+	if *watch && *interval <= 0 {
+		fmt.Fprintln(os.Stderr, "-interval must be positive")
+		return 2
+	} else if *command != "" && *watch == false {
+		fmt.Fprintln(os.Stderr, "-run needs -watch")
+		return 2
+	}
+	for _, arg := range flags.Args() {
+		if *watch && arg == "-" {
+			fmt.Fprintln(os.Stderr, "-watch cannot watch the standard input")
+			return 2
+		}
+	}
+
+	inputs, err2 := expandInputs(flags.Args(), ".asm")
+	if err2 != nil {
+		fmt.Fprintln(os.Stderr, err2)
+		return 1
+	}
+
+	var outputDir string
+	if *output != "" && len(inputs) > 1 {
+		outputDir = *output
+		if err3 := os.MkdirAll(outputDir, 0755); err3 != nil {
+			fmt.Fprintln(os.Stderr, err3)
+			return 1
+		}
+	}
+
+	// Returns the jobs of inputs, whose output paths depend on how many inputs there are
+	buildJobs := func(inputs []string) []batchJob {
+		var jobs []batchJob
+		var writers map[string]string = map[string]string{} // the input that writes each output file
+		for _, input := range inputs {
+			var outputPath string = outputPathFor(input, outputDir, ext)
+			if *output != "" && len(inputs) == 1 {
+				outputPath = *output
+			}
+			var job batchJob = batchJob{input: input, outputs: outputFiles{hack: outputPath, format: *format, object: *object, usage: *reportUsage, options: options}}
+			if other, ok := writers[outputPath]; ok && outputPath != "-" {
+				job.err = fmt.Errorf("%s: %s is written for %s already", input, outputPath, other)
+				jobs = append(jobs, job)
+				continue
+			}
+			writers[outputPath] = input
+			if *listing {
+				job.outputs.listing, job.err = companionPath(input, outputPath, ".lst")
+			}
+			if *symbols != "" && job.err == nil {
+				job.outputs.symbols, job.err = companionPath(input, outputPath, ".sym")
+				job.outputs.symbolFormat = *symbols
+			}
+			if *sourceMap && job.err == nil {
+				job.outputs.sourceMap, job.err = companionPath(input, outputPath, ".map")
+			}
+			jobs = append(jobs, job)
+		}
+		return jobs
+	}
+
+	failed, includes := runJobs(buildJobs(inputs), *workers, *quiet)
+	if *watch == false {
+		if len(failed) > 0 {
+			return 1
+		}
+		return 0
+	}
+	watchInputs(flags.Args(), inputs, failed, includes, *interval, *command, func(changed []string, all []string) (map[string]bool, map[string][]string) {
+		var jobs []batchJob
+		for _, job := range buildJobs(all) {
+			if containsPath(changed, job.input) {
+				jobs = append(jobs, job)
+			}
+		}
+		return runJobs(jobs, *workers, *quiet)
+	})
+	return 0 // not reached, as watchInputs() runs until the program is interrupted
+}
+
+/* Assembles the jobs and reports the outcome of each in the order of the jobs. Returns the inputs
+that failed to assemble and the files that each input included. */
+func runJobs(jobs []batchJob, workers int, quiet bool) (map[string]bool, map[string][]string) {
+	var failed map[string]bool = map[string]bool{}
+	var includes map[string][]string = map[string][]string{}
+	var assembled int = 0
+	var instructions int = 0
+	for index, results := range assembleConcurrently(jobs, workers) {
+		var result *batchResult = <-results // the results are reported in the order of the inputs
+		os.Stderr.Write(result.log.Bytes())
+		includes[jobs[index].input] = result.includes
+		if result.err != nil {
+			fmt.Fprintln(os.Stderr, result.err)
+			failed[jobs[index].input] = true
+			continue
+		}
+		assembled = assembled + 1
+		instructions = instructions + result.instructions
+		if quiet == false {
+			var outputs outputFiles = jobs[index].outputs
+			for _, path := range []string{outputs.hack, outputs.listing, outputs.symbols, outputs.sourceMap} {
+				if path != "" && path != "-" {
+					fmt.Fprintln(os.Stderr, path+" successfully created.")
+				}
+			}
+		}
+	}
+	if len(jobs) > 1 {
+		fmt.Fprintf(os.Stderr, "%d files: %d assembled, %d failed, %d instructions\n", len(jobs), assembled, len(jobs)-assembled, instructions)
+	}
+	return failed, includes
+}
+
+// The state of a watched file that tells whether it has changed, the zero value for a missing file
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func stampFile(path string) fileStamp {
+	info, err1 := os.Stat(path)
+	if err1 != nil {
+		return fileStamp{}
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}
+}
+
+func containsPath(paths []string, path string) bool {
+	for _, element := range paths {
+		if element == path {
+			return true
+		}
+	}
+	return false
+}
+
+/* Polls the inputs named by patterns and the files they include every interval, and calls rebuild
+with the inputs that changed, along with all the inputs, until the program is interrupted. New files
+in a watched directory are assembled as well, and inputs that cannot be found are reported once and
+watched for until they are back. After every build that leaves no input failing, command is run by
+the shell unless it is empty. */
+func watchInputs(patterns []string, inputs []string, failed map[string]bool, includes map[string][]string, interval time.Duration, command string, rebuild func([]string, []string) (map[string]bool, map[string][]string)) {
+	var stamps map[string]fileStamp = map[string]fileStamp{}
+	for _, input := range inputs {
+		stamps[input] = stampFile(input)
+		for _, included := range includes[input] {
+			stamps[included] = stampFile(included)
+		}
+	}
+	if len(failed) == 0 {
+		runCommand(command)
+	}
+	fmt.Fprintln(os.Stderr, "watching for changes, press Ctrl+C to stop")
+	var lastError string = ""
+	for {
+		time.Sleep(interval)
+		current, err1 := expandInputs(patterns, ".asm")
+		if err1 != nil {
+			if err1.Error() != lastError { // reported once, until the inputs are back
+				fmt.Fprintln(os.Stderr, err1)
+				lastError = err1.Error()
+			}
+			continue
+		}
+		lastError = ""
+
+		var changed []string
+		for _, input := range current {
+			var paths []string = append([]string{input}, includes[input]...)
+			for _, path := range paths {
+				if stamp, ok := stamps[path]; ok == false || stampFile(path) != stamp {
+					changed = append(changed, input)
+					break
+				}
+			}
+		}
+		for input := range failed {
+			if containsPath(current, input) == false {
+				delete(failed, input) // a removed input no longer fails
+			}
+		}
+		if len(changed) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(os.Stderr, "[%s] reassembling %s\n", time.Now().Format("15:04:05"), strings.Join(changed, ", "))
+		var started time.Time = time.Now()
+		for _, input := range changed { // stamped before the build, so that an edit during the build is seen by the next poll
+			stamps[input] = stampFile(input)
+			for _, included := range includes[input] {
+				stamps[included] = stampFile(included)
+			}
+		}
+		newFailed, newIncludes := rebuild(changed, current)
+		for _, input := range changed {
+			delete(failed, input)
+			if newFailed[input] {
+				failed[input] = true
+			}
+			for _, included := range newIncludes[input] {
+				if _, ok := stamps[included]; ok == false { // a file included for the first time, known only after the build
+					stamps[included] = stampFile(included)
+					if stamps[included].modTime.After(started) {
+						stamps[included] = fileStamp{modTime: started} // Case: edited during the build, which the next poll rebuilds
+					}
+				}
+			}
+			includes[input] = newIncludes[input]
+		}
+		if len(failed) == 0 {
+			runCommand(command)
+		}
+	}
+}
+
+// Runs command by the shell, with the standard streams of the assembler, unless command is empty
+func runCommand(command string) {
+	if command == "" {
+		return
+	}
+	var shell *exec.Cmd = exec.Command("sh", "-c", command)
+	if runtime.GOOS == "windows" {
+		shell = exec.Command("cmd", "/C", command)
+	}
+	shell.Stdin = os.Stdin
+	shell.Stdout = os.Stdout
+	shell.Stderr = os.Stderr
+	if err1 := shell.Run(); err1 != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", command, err1)
+	}
+}
+
+// An input of a batch along with the files to write for it
+type batchJob struct {
+	input   string
+	outputs outputFiles
+	err     error // why the input cannot be assembled, nil if it can
+}
+
+// The outcome of a batchJob
+type batchResult struct {
+	instructions int
+	log          bytes.Buffer // the messages of the optimizer
+	includes     []string     // the files included by the input
+	err          error
+}
+
+/* Assembles the jobs with up to workers files at a time. Returns one channel per job, in the order of
+the jobs, that receives its result once the file has been assembled. Every file gets its own Parser
+and symbol table, so the workers share nothing but the options. */
+func assembleConcurrently(jobs []batchJob, workers int) []chan *batchResult {
+	var results []chan *batchResult = make([]chan *batchResult, len(jobs))
+	for index := range results {
+		results[index] = make(chan *batchResult, 1)
+	}
+	var queue chan int = make(chan int)
+	for worker := 0; worker < workers; worker++ {
+		go func() {
+			for index := range queue {
+				var result *batchResult = &batchResult{err: jobs[index].err}
+				if result.err == nil {
+					var outputs outputFiles = jobs[index].outputs
+					outputs.options.Includes = &result.includes
+					result.instructions, result.err = assembleFile(jobs[index].input, outputs, &result.log)
+				}
+				results[index] <- result
+			}
+		}()
+	}
+	go func() {
+		for index := range jobs {
+			queue <- index
+		}
+		close(queue)
+	}()
+	return results
+}
+
+const lintUsage = `Usage: hackassembler lint [-disable rule,...] [-rules] [-D NAME=value] [-isa name|table.json] [-aliases] [-pseudo] file.asm|directory|- ...
+
+Checks each Hack assembly file for likely bugs and prints a warning with the line,
+the column and the rule ID of each. A comment such as "// lint:ignore unused-label"
+suppresses the rules it names on its line. Exits with status 1 if there are warnings.
+
+Flags:
+`
+
+// Lints the .asm file at inputPath ("-" for stdin)
+func lintFile(inputPath string, options hackasm.Options, disabled []string) ([]*hackasm.Warning, error) {
+	var input io.Reader = os.Stdin
+	var fileName string = "<stdin>"
+	if inputPath != "-" {
+		file, err1 := os.Open(inputPath)
+		if err1 != nil {
+			return nil, err1
+		}
+		defer file.Close()
+		input = file
+		fileName = inputPath
+	}
+	options.FileName = fileName
+	return hackasm.Lint(input, options, disabled)
+}
+
+// Lints the files named on the command line. Returns the exit status of the program.
+func runLinter(args []string) int {
+	flags := flag.NewFlagSet("hackassembler lint", flag.ContinueOnError)
+	var disable *string = flags.String("disable", "", "comma-separated IDs of the `rules` not to check")
+	var listRules *bool = flags.Bool("rules", false, "list the rules and exit")
+	var predefined symbolDefinitions = symbolDefinitions{}
+	flags.Var(predefined, "D", "predefine a symbol as `NAME=value`, e.g. -D LED=0x6001; may be repeated")
+	var isa *string = flags.String("isa", "hack", "instruction set: "+strings.Join(hackasm.InstructionSetNames(), ", ")+", or the `path` of a JSON table")
+	var aliases *bool = flags.Bool("aliases", false, "accept operands and destinations in another order, such as M+D for D+M or DM for MD")
+	var pseudo *bool = flags.Bool("pseudo", false, "expand pseudo-instructions such as PUSH D, GOTO label and SET addr value")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), lintUsage)
+		flags.PrintDefaults()
+	}
+	if err1 := flags.Parse(args); err1 == flag.ErrHelp {
+		return 0
+	} else if err1 != nil {
+		return 2
+	}
+	if *listRules {
+		rules, descriptions := hackasm.LintRules()
+		for _, rule := range rules {
+			fmt.Printf("%-20s %s\n", rule, descriptions[rule])
+		}
+		return 0
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	var disabled []string
+	if *disable != "" {
+		disabled = strings.Split(*disable, ",")
+	}
+	instructionset, err2 := loadInstructionSet(*isa)
+	if err2 != nil {
+		fmt.Fprintln(os.Stderr, err2)
+		return 2
+	}
+	var options hackasm.Options = hackasm.Options{Predefined: predefined, Aliases: *aliases, Pseudo: *pseudo, InstructionSet: instructionset}
+	_, descriptions := hackasm.LintRules()
+	for _, rule := range disabled {
+		if _, ok := descriptions[rule]; ok == false {
+			fmt.Fprintf(os.Stderr, "unknown lint rule '%s'; see -rules\n", rule)
+			return 2
+		}
+	}
+
+	inputs, err2 := expandInputs(flags.Args(), ".asm")
+	if err2 != nil {
+		fmt.Fprintln(os.Stderr, err2)
+		return 1
+	}
+	var status int = 0
+	for _, input := range inputs {
+		warnings, err3 := lintFile(input, options, disabled)
+		if err3 != nil {
+			fmt.Fprintln(os.Stderr, err3)
+			status = 1
+			continue
+		}
+		for _, warning := range warnings {
+			fmt.Println(warning)
+			status = 1
+		}
+	}
+	return status
+}
+
+const fmtUsage = `Usage: hackassembler fmt [-w] [-check] [-renumber] [-D NAME=value] [-isa name|table.json] [-aliases] [-pseudo] file.asm|directory|- ...
+
+Rewrites each Hack assembly file in the canonical layout: labels and directives in
+the first column, instructions indented by four spaces, aligned trailing comments
+and C-instructions without spaces. The machine code of the file stays the same.
+Prints the formatted files unless -w or -check is given.
+
+Flags:
+`
+
+// Formats the .asm file at inputPath ("-" for stdin), returning the source as read and as formatted
+func formatFile(inputPath string, options hackasm.Options, renumber bool) ([]byte, []byte, error) {
+	var input io.Reader = os.Stdin
+	var fileName string = "<stdin>"
+	if inputPath != "-" {
+		file, err1 := os.Open(inputPath)
+		if err1 != nil {
+			return nil, nil, err1
+		}
+		defer file.Close()
+		input = file
+		fileName = inputPath
+	}
+	source, err2 := ioutil.ReadAll(input)
+	if err2 != nil {
+		return nil, nil, err2
+	}
+	options.FileName = fileName
+	formatted, err3 := hackasm.Format(bytes.NewReader(source), options, renumber)
+	return source, formatted, err3
+}
+
+// Formats the files named on the command line. Returns the exit status of the program.
+func runFormatter(args []string) int {
+	flags := flag.NewFlagSet("hackassembler fmt", flag.ContinueOnError)
+	var write *bool = flags.Bool("w", false, "rewrite the files that are not formatted instead of printing them")
+	var check *bool = flags.Bool("check", false, "list the files that are not formatted, and exit with status 1 if there are any")
+	var renumber *bool = flags.Bool("renumber", false, "renumber the labels that end in a number, such as L_0042, in the order they are declared")
+	var predefined symbolDefinitions = symbolDefinitions{}
+	flags.Var(predefined, "D", "predefine a symbol as `NAME=value`, e.g. -D LED=0x6001; may be repeated")
+	var isa *string = flags.String("isa", "hack", "instruction set: "+strings.Join(hackasm.InstructionSetNames(), ", ")+", or the `path` of a JSON table")
+	var aliases *bool = flags.Bool("aliases", false, "accept operands and destinations in another order, such as M+D for D+M or DM for MD, and rewrite them in the order of the instruction set")
+	var pseudo *bool = flags.Bool("pseudo", false, "expand pseudo-instructions such as PUSH D, GOTO label and SET addr value")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), fmtUsage)
+		flags.PrintDefaults()
+	}
+	if err1 := flags.Parse(args); err1 == flag.ErrHelp {
+		return 0
+	} else if err1 != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	instructionset, err2 := loadInstructionSet(*isa)
+	if err2 != nil {
+		fmt.Fprintln(os.Stderr, err2)
+		return 2
+	}
+	var options hackasm.Options = hackasm.Options{Predefined: predefined, Aliases: *aliases, Pseudo: *pseudo, InstructionSet: instructionset}
+
+	inputs, err3 := expandInputs(flags.Args(), ".asm")
+	if err3 != nil {
+		fmt.Fprintln(os.Stderr, err3)
+		return 1
+	}
+	var status int = 0
+	for _, input := range inputs {
+		source, formatted, err4 := formatFile(input, options, *renumber)
+		if err4 != nil {
+			fmt.Fprintln(os.Stderr, err4)
+			status = 1
+			continue
+		}
+		var changed bool = bytes.Equal(source, formatted) == false
+		if *check {
+			if changed {
+				fmt.Println(input)
+				status = 1
+			}
+		} else if *write && input != "-" {
+			if changed {
+				if err5 := ioutil.WriteFile(input, formatted, 0644); err5 != nil {
+					fmt.Fprintln(os.Stderr, err5)
+					status = 1
+				}
+			}
+		} else {
+			os.Stdout.Write(formatted)
+		}
+	}
+	return status
+}
+
+const linkUsage = `Usage: hackassembler link [-o output] [-f format] [-q] [-sym json|text] file.obj|directory ...
+
+Links the object files written by "hackassembler -c" into one program, named after
+the first object file unless -o is given. The code of the first file starts at ROM
+address 0, and the code of every other file follows in the order of the arguments.
+
+Flags:
+`
+
+// Links the object files named on the command line. Returns the exit status of the program.
+func runLinker(args []string) int {
+	flags := flag.NewFlagSet("hackassembler link", flag.ContinueOnError)
+	var output *string = flags.String("o", "", "output `path`, or - for stdout")
+	var format *string = flags.String("f", "hack", "output `format`: "+strings.Join(hackasm.OutputFormatNames(), ", "))
+	var quiet *bool = flags.Bool("q", false, "do not report the files that were created")
+	var symbols *string = flags.String("sym", "", "also write a .sym symbol file of the exported labels and the variables, in `format` json or text")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), linkUsage)
+		flags.PrintDefaults()
+	}
+	if err1 := flags.Parse(args); err1 == flag.ErrHelp {
+		return 0
+	} else if err1 != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	if *symbols != "" && *symbols != "json" && *symbols != "text" {
+		fmt.Fprintf(os.Stderr, "unknown symbol file format '%s'\n", *symbols)
+		return 2
+	}
+	ext, ok := hackasm.FormatExtension(*format)
+	if ok == false {
+		fmt.Fprintf(os.Stderr, "unknown output format '%s'; expected one of %s\n", *format, strings.Join(hackasm.OutputFormatNames(), ", "))
+		return 2
+	}
+
+	inputs, err2 := expandInputs(flags.Args(), ".obj")
+	if err2 != nil {
+		fmt.Fprintln(os.Stderr, err2)
+		return 1
+	}
+	var objects []*hackasm.Object
+	for _, input := range inputs {
+		file, err3 := os.Open(input)
+		if err3 != nil {
+			fmt.Fprintln(os.Stderr, err3)
+			return 1
+		}
+		object, err4 := hackasm.ReadObject(file, input)
+		file.Close()
+		if err4 != nil {
+			fmt.Fprintln(os.Stderr, err4)
+			return 1
+		}
+		objects = append(objects, object)
+	}
+
+	words, symboltable, err5 := hackasm.Link(objects)
+	if err5 != nil {
+		fmt.Fprintln(os.Stderr, err5)
+		return 1
+	}
+	var outputs outputFiles = outputFiles{hack: *output, format: *format}
+	if outputs.hack == "" {
+		outputs.hack = strings.TrimSuffix(inputs[0], ".obj") + ext
+	}
+	if *symbols != "" {
+		symbolPath, err6 := companionPath(inputs[0], outputs.hack, ".sym")
+		if err6 != nil {
+			fmt.Fprintln(os.Stderr, err6)
+			return 1
+		}
+		outputs.symbols = symbolPath
+		outputs.symbolFormat = *symbols
+	}
+	var hack bytes.Buffer
+	if err7 := hackasm.EncodeWords(&hack, words, *format, outputs.hack); err7 != nil {
+		fmt.Fprintln(os.Stderr, err7)
+		return 1
+	}
+	if err8 := writeOutputs(outputs, hack.Bytes(), nil, symboltable, outputs.hack); err8 != nil {
+		fmt.Fprintln(os.Stderr, err8)
+		return 1
+	}
+	if *quiet == false {
+		for _, path := range []string{outputs.hack, outputs.symbols} {
+			if path != "" && path != "-" {
+				fmt.Fprintln(os.Stderr, path+" successfully created.")
+			}
+		}
+	}
+	return 0
+}
+
+const benchUsage = `Usage: hackassembler bench [-f format] [-O] [-limit duration] [file.asm|directory ...]
+
+Measures how long the assembler takes to translate each file, the bundled
+asm_files by default, along with the memory it allocates. Every file is read into
+memory first, so the times leave out the disk. Exits with status 1 if a file takes
+longer than -limit.
+
+Flags:
+`
+
+// Benchmarks the assembler on the files named on the command line. Returns the exit status of the program.
+func runBenchmarks(args []string) int {
+	flags := flag.NewFlagSet("hackassembler bench", flag.ContinueOnError)
+	var format *string = flags.String("f", "", "also encode the machine code in `format` "+strings.Join(hackasm.OutputFormatNames(), ", ")+", which is left out by default")
+	var optimize *bool = flags.Bool("O", false, "run the optimizer as well")
+	var limit *time.Duration = flags.Duration("limit", 0, "fail if assembling any file takes longer than `duration`, e.g. 5ms")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), benchUsage)
+		flags.PrintDefaults()
+	}
+	if err1 := flags.Parse(args); err1 == flag.ErrHelp {
+		return 0
+	} else if err1 != nil {
+		return 2
+	}
+	if _, ok := hackasm.FormatExtension(*format); *format != "" && ok == false {
+		fmt.Fprintf(os.Stderr, "unknown output format '%s'; expected one of %s\n", *format, strings.Join(hackasm.OutputFormatNames(), ", "))
+		return 2
+	}
+	var paths []string = flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"asm_files"}
+	}
+	inputs, err2 := expandInputs(paths, ".asm")
+	if err2 != nil {
+		fmt.Fprintln(os.Stderr, err2)
+		return 1
+	}
+
+	var status int = 0
+	fmt.Printf("%-24s %8s %12s %12s %10s\n", "FILE", "WORDS", "TIME/OP", "BYTES/OP", "ALLOCS/OP")
+	for _, input := range inputs {
+		source, err3 := ioutil.ReadFile(input)
+		if err3 != nil {
+			fmt.Fprintln(os.Stderr, err3)
+			status = 1
+			continue
+		}
+		var options hackasm.Options = hackasm.Options{FileName: input, Optimize: *optimize}
+		words, _, err4 := hackasm.Assemble(bytes.NewReader(source), options)
+		if err4 != nil {
+			fmt.Fprintln(os.Stderr, err4)
+			status = 1
+			continue
+		}
+		perOp, bytesPerOp, allocsPerOp := measureAssembly(source, options, *format)
+		fmt.Printf("%-24s %8d %12v %12d %10d\n", filepath.Base(input), len(words), perOp, bytesPerOp, allocsPerOp)
+		if *limit > 0 && perOp > *limit {
+			fmt.Fprintf(os.Stderr, "%s: %v per run exceeds the limit of %v\n", input, perOp, *limit)
+			status = 1
+		}
+	}
+	return status
+}
+
+/* Assembles source again and again for about a second, encoding the words in format unless it is "".
+Returns the time, the bytes allocated and the number of allocations of one run on average. */
+func measureAssembly(source []byte, options hackasm.Options, format string) (time.Duration, uint64, uint64) {
+	var before, after runtime.MemStats
+	runtime.GC() // so that the garbage of the previous file is not collected during the runs of this one
+	runtime.ReadMemStats(&before)
+	var start time.Time = time.Now()
+	var runs int = 0
+	for runs == 0 || time.Since(start) < time.Second {
+		words, _, _ := hackasm.Assemble(bytes.NewReader(source), options)
+		if format != "" {
+			hackasm.EncodeWords(ioutil.Discard, words, format, options.FileName)
+		}
+		runs = runs + 1
+	}
+	var elapsed time.Duration = time.Since(start)
+	runtime.ReadMemStats(&after)
+	return elapsed / time.Duration(runs), (after.TotalAlloc - before.TotalAlloc) / uint64(runs), (after.Mallocs - before.Mallocs) / uint64(runs)
+}
+
+const disasmUsage = `Usage: hackassembler disasm [-o output] [-vm] file.hack|directory|- ...
+
+Translates each .hack file back into Hack assembly, written to the standard output
+unless -o is given. Jump targets get labels such as (L_0042), and words that are not
+valid instructions are kept as raw-data comments.
+
+Flags:
+`
+
+// Disassembles the .hack files named on the command line. Returns the exit status of the program.
+func runDisassembler(args []string) int {
+	flags := flag.NewFlagSet("hackassembler disasm", flag.ContinueOnError)
+	var output *string = flags.String("o", "", "output `path`: a file for a single input, a directory for several")
+	var vmNames *bool = flags.Bool("vm", false, "name the addresses 0-4 SP, LCL, ARG, THIS and THAT instead of R0-R4")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), disasmUsage)
+		flags.PrintDefaults()
+	}
+	if err1 := flags.Parse(args); err1 == flag.ErrHelp {
+		return 0
+	} else if err1 != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	inputs, err2 := expandInputs(flags.Args(), ".hack")
+	if err2 != nil {
+		fmt.Fprintln(os.Stderr, err2)
+		return 1
+	}
+	if *output != "" && len(inputs) > 1 {
+		if err3 := os.MkdirAll(*output, 0755); err3 != nil {
+			fmt.Fprintln(os.Stderr, err3)
+			return 1
+		}
+	}
+
+	var status int = 0
+	for _, input := range inputs {
+		var outputPath string = "-"
+		if *output != "" && len(inputs) == 1 {
+			outputPath = *output
+		} else if *output != "" && input != "-" {
+			outputPath = filepath.Join(*output, strings.TrimSuffix(filepath.Base(input), ".hack")+".asm")
+		}
+		err4 := disassembleFile(input, outputPath, *vmNames)
+		if err4 != nil {
+			fmt.Fprintln(os.Stderr, err4)
+			status = 1
+		}
+	}
+	return status
+}
+
+const compareUsage = `Usage: hackassembler compare [-map first.map] first.hack second.hack
+
+Compares two .hack files, such as a translation and the reference, word by word and
+explains every ROM address at which they differ in terms of the fields of the
+instructions, e.g. "addr 7: comp D+M vs D+A (a-bit differs)". With -map, every
+difference also names the source line and the label of the word of the first file.
+Exits with status 1 if the files differ.
+
+Flags:
+`
+
+// Reads the words of the .hack file at path ("-" for stdin)
+func readHackFile(path string) ([]uint16, error) {
+	if path == "-" {
+		return hackasm.ReadHack(os.Stdin, "<stdin>")
+	}
+	file, err1 := os.Open(path)
+	if err1 != nil {
+		return nil, err1
+	}
+	defer file.Close()
+	return hackasm.ReadHack(file, path)
+}
+
+// Compares the two .hack files named on the command line. Returns the exit status of the program.
+func runCompare(args []string) int {
+	flags := flag.NewFlagSet("hackassembler compare", flag.ContinueOnError)
+	var sourceMapPath *string = flags.String("map", "", "the source `map` of the first file, written by -map")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), compareUsage)
+		flags.PrintDefaults()
+	}
+	if err1 := flags.Parse(args); err1 == flag.ErrHelp {
+		return 0
+	} else if err1 != nil {
+		return 2
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+
+	first, err2 := readHackFile(flags.Arg(0))
+	if err2 != nil {
+		fmt.Fprintln(os.Stderr, err2)
+		return 2
+	}
+	second, err3 := readHackFile(flags.Arg(1))
+	if err3 != nil {
+		fmt.Fprintln(os.Stderr, err3)
+		return 2
+	}
+	var sourceMap *hackasm.SourceMap
+	if *sourceMapPath != "" {
+		file, err4 := os.Open(*sourceMapPath)
+		if err4 != nil {
+			fmt.Fprintln(os.Stderr, err4)
+			return 2
+		}
+		sourceMap, err4 = hackasm.ReadSourceMap(file, *sourceMapPath)
+		file.Close()
+		if err4 != nil {
+			fmt.Fprintln(os.Stderr, err4)
+			return 2
+		}
+		if len(sourceMap.Words) != len(first) {
+			fmt.Fprintf(os.Stderr, "%s: the source map covers %d words, but %s holds %d\n", *sourceMapPath, len(sourceMap.Words), flags.Arg(0), len(first))
+			return 2
+		}
+	}
+
+	var mismatches []*hackasm.Mismatch = hackasm.Compare(first, second, sourceMap)
+	for _, mismatch := range mismatches {
+		fmt.Println(mismatch)
+	}
+	if len(mismatches) == 0 {
+		fmt.Printf("%s and %s are identical (%s)\n", flags.Arg(0), flags.Arg(1), countWords(len(first)))
+		return 0
+	}
+	var differ string = "differ"
+	if len(mismatches) == 1 {
+		differ = "differs"
+	}
+	fmt.Printf("%s %s (%s: %s, %s: %s)\n", countWords(len(mismatches)), differ, flags.Arg(0), countWords(len(first)), flags.Arg(1), countWords(len(second)))
+	return 1
+}
+
+// Returns count followed by "word" or "words", e.g. "1 word" or "3 words"
+func countWords(count int) string {
+	if count == 1 {
+		return "1 word"
+	}
+	return fmt.Sprintf("%d words", count)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		os.Exit(runInteractive())
+	}
+	var command string = os.Args[1]
+	if _, err1 := os.Stat(command); err1 == nil {
+		command = "" // Case: an input that is named like a subcommand, such as a file called lint
+	}
+	switch command {
+	case "lint":
+		os.Exit(runLinter(os.Args[2:]))
+	case "fmt":
+		os.Exit(runFormatter(os.Args[2:]))
+	case "link":
+		os.Exit(runLinker(os.Args[2:]))
+	case "bench":
+		os.Exit(runBenchmarks(os.Args[2:]))
+	case "disasm":
+		os.Exit(runDisassembler(os.Args[2:]))
+	case "compare":
+		os.Exit(runCompare(os.Args[2:]))
+	default:
+		os.Exit(runBatch(os.Args[1:]))
+	}
 }